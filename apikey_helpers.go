@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/LoronsoDev/chirpy/internal/auth"
+	"github.com/LoronsoDev/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxAPIKeyNameLength = 100
+
+var errInvalidAPIKey = errors.New("Invalid, expired or revoked API key")
+
+// APIKey is an API key as shown to its owner. The key itself is only ever
+// in the response that created it.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Key        string     `json:"key,omitempty"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Revoked    bool       `json:"revoked"`
+}
+
+func newAPIKeyResponse(dbKey database.ApiKey) APIKey {
+	key := APIKey{
+		ID:        dbKey.ID,
+		Name:      dbKey.Name,
+		Prefix:    dbKey.Prefix,
+		Scopes:    dbKey.Scopes,
+		CreatedAt: dbKey.CreatedAt,
+		Revoked:   dbKey.RevokedAt.Valid,
+	}
+	if dbKey.ExpiresAt.Valid {
+		key.ExpiresAt = &dbKey.ExpiresAt.Time
+	}
+	if dbKey.LastUsedAt.Valid {
+		key.LastUsedAt = &dbKey.LastUsedAt.Time
+	}
+	return key
+}
+
+// authenticateAPIKey looks up the key a request was sent with and returns
+// claims acting as its owner within the key's scopes.
+func (apiCfg *apiConfig) authenticateAPIKey(ctx context.Context, key string) (auth.Claims, error) {
+	dbKey, err := apiCfg.db.GetAPIKeyByHash(ctx, auth.HashAPIKey(key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.Claims{}, errInvalidAPIKey
+	}
+	if err != nil {
+		return auth.Claims{}, err
+	}
+	if dbKey.RevokedAt.Valid || (dbKey.ExpiresAt.Valid && dbKey.ExpiresAt.Time.Before(time.Now())) {
+		return auth.Claims{}, errInvalidAPIKey
+	}
+	// Keys are left alone by a suspension, so they work again when it's
+	// lifted, but they can't be used while it lasts.
+	if err := apiCfg.checkNotSuspended(ctx, dbKey.UserID); err != nil {
+		return auth.Claims{}, err
+	}
+
+	scopes, err := auth.ParseScopes(dbKey.Scopes)
+	if err != nil || len(scopes) == 0 {
+		return auth.Claims{}, fmt.Errorf("API key %s has invalid scopes: %w", dbKey.ID, err)
+	}
+
+	// A failure here shouldn't fail the request, only make last_used_at
+	// a little stale.
+	if err := apiCfg.db.TouchAPIKey(ctx, dbKey.ID); err != nil {
+		log.Printf("Error updating last use of API key %s: %v", dbKey.ID, err)
+	}
+	return auth.APIKeyClaims(dbKey.UserID, scopes), nil
+}
+
+func respondInvalidAPIKey(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUserSuspended) {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if !errors.Is(err, errInvalidAPIKey) {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("WWW-Authenticate", `ApiKey realm="chirpy"`)
+	respondWithError(w, http.StatusUnauthorized, err.Error())
+}
+
+// respondInsufficientScope rejects scoped credentials used on an endpoint
+// outside their scope, or that doesn't take scoped credentials at all.
+func respondInsufficientScope(w http.ResponseWriter, scope auth.Scope) {
+	if scope == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="chirpy", error="insufficient_scope"`)
+		respondWithError(w, http.StatusForbidden, "API keys and app tokens can't be used for this endpoint")
+		return
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="chirpy", error="insufficient_scope", scope=%q`, scope))
+	respondWithError(w, http.StatusForbidden, fmt.Sprintf("These credentials don't have the %s scope", scope))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/LoronsoDev/chirpy/internal/chirptext"
+	"github.com/LoronsoDev/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// getOriginalChirp looks up a live chirp the viewer can see, following a
+// rechirp to the chirp it amplifies so replies, quotes and rechirps always
+// point at the original.
+func (apiCfg *apiConfig) getOriginalChirp(ctx context.Context, chirpID uuid.UUID, viewerID uuid.NullUUID) (database.Chirp, error) {
+	dbChirp, err := apiCfg.db.GetChirp(ctx, chirpID)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	if dbChirp.RechirpOf.Valid {
+		dbChirp, err = apiCfg.db.GetChirp(ctx, dbChirp.RechirpOf.UUID)
+		if err != nil {
+			return database.Chirp{}, err
+		}
+	}
+	if dbChirp.DeletedAt.Valid || !apiCfg.canSeeChirp(ctx, dbChirp, viewerID) {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+	return dbChirp, nil
+}
+
+// indexChirpTags replaces the hashtags stored for a chirp with the ones in
+// its current body. Tags keep the chirp's creation time so edits don't bump
+// them up the trending list.
+func indexChirpTags(ctx context.Context, qtx *database.Queries, dbChirp database.Chirp) error {
+	if err := qtx.RemoveChirpTags(ctx, dbChirp.ID); err != nil {
+		return err
+	}
+
+	tags := chirptext.Hashtags(dbChirp.Body)
+	if len(tags) == 0 {
+		return nil
+	}
+	return qtx.AddChirpTags(ctx, database.AddChirpTagsParams{
+		ChirpID:   dbChirp.ID,
+		Tags:      tags,
+		CreatedAt: dbChirp.CreatedAt,
+	})
+}
+
+// chirpResponses converts chirps for the API with their like information and
+// embeds the chirp each rechirp or quote references, one level deep. A
+// reference to a chirp hidden from the viewer is left empty.
+func (apiCfg *apiConfig) chirpResponses(ctx context.Context, dbChirps []database.Chirp, viewerID uuid.NullUUID) ([]Chirp, error) {
+	chirps, err := apiCfg.chirpResponsesWithLikes(ctx, dbChirps, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	refIDs := []uuid.UUID{}
+	for _, dbChirp := range dbChirps {
+		if ref := referencedChirpID(dbChirp); ref.Valid {
+			refIDs = append(refIDs, ref.UUID)
+		}
+	}
+	if len(refIDs) == 0 {
+		return chirps, nil
+	}
+
+	dbRefs, err := apiCfg.db.GetChirpsByIDs(ctx, refIDs)
+	if err != nil {
+		return nil, err
+	}
+	dbRefs = apiCfg.visibleChirps(ctx, dbRefs, viewerID)
+	refs, err := apiCfg.chirpResponsesWithLikes(ctx, dbRefs, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	refsByID := make(map[uuid.UUID]*Chirp, len(refs))
+	for i := range refs {
+		refsByID[refs[i].ID] = &refs[i]
+	}
+
+	for i, dbChirp := range dbChirps {
+		if ref := referencedChirpID(dbChirp); ref.Valid {
+			chirps[i].ReferencedChirp = refsByID[ref.UUID]
+		}
+	}
+	return chirps, nil
+}
+
+// visibleChirps drops the chirps canSeeChirp would hide from the viewer,
+// looking up whether they're a moderator at most once.
+func (apiCfg *apiConfig) visibleChirps(ctx context.Context, dbChirps []database.Chirp, viewerID uuid.NullUUID) []database.Chirp {
+	moderator := sync.OnceValue(func() bool {
+		return apiCfg.isModerator(ctx, viewerID)
+	})
+	visible := dbChirps[:0]
+	for _, dbChirp := range dbChirps {
+		if !dbChirp.HiddenAt.Valid || (viewerID.Valid && viewerID.UUID == dbChirp.UserID) || moderator() {
+			visible = append(visible, dbChirp)
+		}
+	}
+	return visible
+}
+
+func referencedChirpID(dbChirp database.Chirp) uuid.NullUUID {
+	if dbChirp.RechirpOf.Valid {
+		return dbChirp.RechirpOf
+	}
+	return dbChirp.QuoteOf
+}
+
+// chirpResponsesWithLikes fills in like counts and, when viewerID is set,
+// whether the viewer liked each chirp. Counts are aggregated from chirp_likes
+// instead of being kept in a counter column, so concurrent likes can't leave
+// them out of sync.
+func (apiCfg *apiConfig) chirpResponsesWithLikes(ctx context.Context, dbChirps []database.Chirp, viewerID uuid.NullUUID) ([]Chirp, error) {
+	chirps := make([]Chirp, 0, len(dbChirps))
+	chirpIDs := make([]uuid.UUID, 0, len(dbChirps))
+	for _, dbChirp := range dbChirps {
+		chirps = append(chirps, newChirpResponse(dbChirp))
+		chirpIDs = append(chirpIDs, dbChirp.ID)
+	}
+	if len(chirpIDs) == 0 {
+		return chirps, nil
+	}
+
+	likeCounts, err := apiCfg.db.CountChirpLikes(ctx, chirpIDs)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[uuid.UUID]int64, len(likeCounts))
+	for _, likeCount := range likeCounts {
+		counts[likeCount.ChirpID] = likeCount.LikeCount
+	}
+
+	liked := map[uuid.UUID]bool{}
+	if viewerID.Valid {
+		likedIDs, err := apiCfg.db.GetLikedChirpIDs(ctx, database.GetLikedChirpIDsParams{
+			UserID:   viewerID.UUID,
+			ChirpIds: chirpIDs,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, likedID := range likedIDs {
+			liked[likedID] = true
+		}
+	}
+
+	for i := range chirps {
+		chirps[i].LikeCount = counts[chirps[i].ID]
+		chirps[i].LikedByMe = liked[chirps[i].ID]
+	}
+	return chirps, nil
+}
+
+func (apiCfg *apiConfig) chirpResponse(ctx context.Context, dbChirp database.Chirp, viewerID uuid.NullUUID) (Chirp, error) {
+	chirps, err := apiCfg.chirpResponses(ctx, []database.Chirp{dbChirp}, viewerID)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirps[0], nil
+}
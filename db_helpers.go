@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+
+	"github.com/LoronsoDev/chirpy/internal/database"
+)
+
+// withTx runs fn inside a database transaction, committing only if fn
+// succeeds.
+func (apiCfg *apiConfig) withTx(ctx context.Context, fn func(qtx *database.Queries) error) error {
+	tx, err := apiCfg.sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(apiCfg.db.WithTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
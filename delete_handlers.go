@@ -1,43 +1,429 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/LoronsoDev/chirpy/internal/auth"
+	"github.com/LoronsoDev/chirpy/internal/database"
 	"github.com/google/uuid"
 )
 
 func (apiCfg apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request) {
 	chirpIDStr := r.PathValue("chirpID")
 	chirpId, err := uuid.Parse(chirpIDStr)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	defer r.Body.Close()
+
+	userID := userIDFromContext(r.Context())
+
+	err = apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		retChirp, err := qtx.GetChirpForUpdate(r.Context(), chirpId)
+		if err != nil {
+			return err
+		}
+		if retChirp.DeletedAt.Valid {
+			return sql.ErrNoRows
+		}
+		if retChirp.UserID != userID {
+			return errNotChirpOwner
+		}
+		return removeChirp(r.Context(), qtx, retChirp)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+	if errors.Is(err, errNotChirpOwner) {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusNoContent, struct{}{})
+}
+
+// removeChirp deletes a chirp, leaving a tombstone instead when other chirps
+// still reply to or quote it so the conversation isn't cascaded away.
+// Rechirps have nothing to show without their original, so they go with it.
+// Tombstones of the chirps it replied to or quoted that are left
+// unreferenced are cleaned up on the way out.
+func removeChirp(ctx context.Context, qtx *database.Queries, chirp database.Chirp) error {
+	references, err := qtx.CountChirpReferences(ctx, chirp.ID)
+	if err != nil {
+		return err
+	}
+	if references > 0 {
+		if err := qtx.TombstoneChirp(ctx, chirp.ID); err != nil {
+			return err
+		}
+		if err := qtx.RemoveRechirpsOf(ctx, uuid.NullUUID{UUID: chirp.ID, Valid: true}); err != nil {
+			return err
+		}
+		if err := qtx.RemoveChirpTags(ctx, chirp.ID); err != nil {
+			return err
+		}
+		return qtx.RemoveChirpRevisions(ctx, chirp.ID)
+	}
+
+	if err := qtx.RemoveChirp(ctx, chirp.ID); err != nil {
+		return err
+	}
+
+	// Removing a chirp can leave the tombstone it replied to or quoted
+	// unreferenced, and removing that one can do the same in turn.
+	pending := referencedTombstones(chirp)
+	for len(pending) > 0 {
+		id := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		tombstone, err := qtx.GetChirpForUpdate(ctx, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !tombstone.DeletedAt.Valid {
+			continue
+		}
+
+		references, err := qtx.CountChirpReferences(ctx, tombstone.ID)
+		if err != nil {
+			return err
+		}
+		if references > 0 {
+			continue
+		}
+		if err := qtx.RemoveChirp(ctx, tombstone.ID); err != nil {
+			return err
+		}
+		pending = append(pending, referencedTombstones(tombstone)...)
+	}
+	return nil
+}
+
+// referencedTombstones are the chirps that may have been kept as tombstones
+// only because chirp replied to or quoted them.
+func referencedTombstones(chirp database.Chirp) []uuid.UUID {
+	ids := []uuid.UUID{}
+	if chirp.ReplyTo.Valid {
+		ids = append(ids, chirp.ReplyTo.UUID)
+	}
+	if chirp.QuoteOf.Valid && chirp.QuoteOf != chirp.ReplyTo {
+		ids = append(ids, chirp.QuoteOf.UUID)
+	}
+	return ids
+}
+
+func (apiCfg *apiConfig) handlerUnfollowUser(w http.ResponseWriter, r *http.Request) {
+	followeeID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	err = apiCfg.db.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: userID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusNoContent, struct{}{})
+}
 
-	token, err := auth.GetBearerToken(r.Header)
+func (apiCfg *apiConfig) handlerUnlikeChirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	dbChirp, err := apiCfg.db.GetChirp(r.Context(), chirpID)
+	if err != nil || dbChirp.DeletedAt.Valid || !apiCfg.canSeeChirp(r.Context(), dbChirp, uuid.NullUUID{UUID: userID, Valid: true}) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+
+	err = apiCfg.db.UnlikeChirp(r.Context(), database.UnlikeChirpParams{
+		ChirpID: chirpID,
+		UserID:  userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	chirp, err := apiCfg.chirpResponse(r.Context(), dbChirp, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, likeStatus{LikeCount: chirp.LikeCount, LikedByMe: chirp.LikedByMe})
+}
+
+func (apiCfg *apiConfig) handlerUndoRechirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+	if err := apiCfg.checkNotSuspended(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	original, err := apiCfg.getOriginalChirp(r.Context(), chirpID, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
 
+	removed, err := apiCfg.db.RemoveRechirp(r.Context(), database.RemoveRechirpParams{
+		UserID:    userID,
+		RechirpOf: uuid.NullUUID{UUID: original.ID, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if removed == 0 {
+		respondWithError(w, http.StatusNotFound, "You haven't rechirped this chirp")
+		return
+	}
+	respondWithJSON(w, http.StatusNoContent, struct{}{})
+}
+
+func (apiCfg *apiConfig) handlerUnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	unsuspended, err := apiCfg.db.UnsuspendUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if unsuspended == 0 {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	respondWithJSON(w, http.StatusNoContent, struct{}{})
+}
+
+// handlerRevokeSession logs one session out. Its refresh token and the access
+// tokens already issued to it stop working right away.
+func (apiCfg *apiConfig) handlerRevokeSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	revoked, err := apiCfg.db.RevokeSession(r.Context(), database.RevokeSessionParams{
+		FamilyID: sessionID,
+		UserID:   userIDFromContext(r.Context()),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	respondWithJSON(w, http.StatusNoContent, struct{}{})
+}
+
+// handlerRevokeAllSessions logs the user out everywhere, including access
+// tokens that haven't expired yet and apps they authorized through OAuth.
+func (apiCfg *apiConfig) handlerRevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r.Context())
+
+	err := apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		if err := qtx.RevokeUserRefreshTokens(r.Context(), userID); err != nil {
+			return err
+		}
+		if err := qtx.RevokeUserOAuthGrants(r.Context(), userID); err != nil {
+			return err
+		}
+		return qtx.IncrementTokenVersion(r.Context(), userID)
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusNoContent, struct{}{})
+}
+
+// handlerDisableTOTP turns off two-factor authentication. A stolen access
+// token isn't enough: the user has to enter their password, if they have
+// one, and a code again.
+func (apiCfg *apiConfig) handlerDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	type incomingParams struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	incParams := incomingParams{}
+	if err := json.NewDecoder(r.Body).Decode(&incParams); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
 	defer r.Body.Close()
 
+	user, err := apiCfg.db.GetUser(r.Context(), userIDFromContext(r.Context()))
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Provided JWT token can't be retrieved correctly")
+		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	userID, err := auth.ValidateJWT(token, apiCfg.jwtSecret)
+	// Users who only sign in with an identity provider have no password to
+	// enter, so the code alone has to do for them.
+	if user.HashedPassword != "" {
+		if err := auth.CheckPasswordHash(incParams.Password, user.HashedPassword); err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Incorrect password")
+			return
+		}
+	}
+
+	// Wrong codes count towards the user's backoff, so they're reported
+	// through failure and the transaction still commits.
+	var failure error
+	var wait time.Duration
+	err = apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		var err error
+		wait, err = verifySecondFactor(r.Context(), qtx, user.ID, incParams.Code, incParams.RecoveryCode)
+		if wait > 0 {
+			return nil
+		}
+		if errors.Is(err, errTOTPNotEnabled) || errors.Is(err, errInvalidMFACode) {
+			failure = err
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := qtx.DeleteUserTOTP(r.Context(), user.ID); err != nil {
+			return err
+		}
+		return qtx.DeleteUserRecoveryCodes(r.Context(), user.ID)
+	})
+	switch {
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	case wait > 0:
+		respondTooManyLoginAttempts(w, wait)
+		return
+	case errors.Is(failure, errTOTPNotEnabled):
+		respondWithError(w, http.StatusConflict, failure.Error())
+		return
+	case errors.Is(failure, errInvalidMFACode):
+		respondWithError(w, http.StatusUnauthorized, failure.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, struct{}{})
+}
+
+func (apiCfg *apiConfig) handlerRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	revoked, err := apiCfg.db.RevokeAPIKey(r.Context(), database.RevokeAPIKeyParams{
+		ID:     keyID,
+		UserID: userIDFromContext(r.Context()),
+	})
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, err.Error())
+		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	retChirp, err := apiCfg.db.GetChirp(r.Context(), chirpId)
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "API key not found")
+		return
+	}
+	respondWithJSON(w, http.StatusNoContent, struct{}{})
+}
+
+// handlerDeleteOAuthClient deletes one of the caller's OAuth clients, along
+// with everything users granted it.
+func (apiCfg *apiConfig) handlerDeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	if retChirp.UserID == userID {
-		err := apiCfg.db.RemoveChirp(r.Context(), chirpId)
+
+	deleted, err := apiCfg.db.DeleteOAuthClient(r.Context(), database.DeleteOAuthClientParams{
+		ID:      clientID,
+		OwnerID: userIDFromContext(r.Context()),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "OAuth client not found")
+		return
+	}
+	respondWithJSON(w, http.StatusNoContent, struct{}{})
+}
+
+// handlerRevokeOAuthConsent takes back the access the caller gave an app.
+// Its tokens stop working immediately, and it has to ask again to get new
+// ones.
+func (apiCfg *apiConfig) handlerRevokeOAuthConsent(w http.ResponseWriter, r *http.Request) {
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+	var deleted int64
+	err = apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		deleted, err = qtx.DeleteOAuthConsent(r.Context(), database.DeleteOAuthConsentParams{
+			UserID:   userID,
+			ClientID: clientID,
+		})
 		if err != nil {
-			respondWithError(w, http.StatusNotFound, err.Error())
-			return
+			return err
 		}
-		respondWithJSON(w, http.StatusNoContent, struct{}{})
+		return qtx.RevokeUserClientOAuthGrants(r.Context(), database.RevokeUserClientOAuthGrantsParams{
+			UserID:   userID,
+			ClientID: clientID,
+		})
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Consent not found")
 		return
 	}
-	respondWithError(w, http.StatusForbidden, "you are not the original poster of this chirp")
-	return
+	respondWithJSON(w, http.StatusNoContent, struct{}{})
 }
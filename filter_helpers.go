@@ -0,0 +1,92 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/LoronsoDev/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// chirpFilter describes which chirps a listing should return. Every field is
+// optional and they all combine with AND, e.g. "these authors, since
+// yesterday, newest first".
+type chirpFilter struct {
+	AuthorIDs []uuid.UUID
+	Since     sql.NullTime
+	Until     sql.NullTime
+	Desc      bool
+}
+
+// parseChirpFilter reads author_id (repeated or comma separated), since and
+// until (RFC 3339, since inclusive and until exclusive) and sort (asc/desc).
+func parseChirpFilter(r *http.Request) (chirpFilter, error) {
+	query := r.URL.Query()
+	filter := chirpFilter{}
+
+	for _, authorIDs := range query["author_id"] {
+		for _, authorIDStr := range strings.Split(authorIDs, ",") {
+			authorID, err := uuid.Parse(strings.TrimSpace(authorIDStr))
+			if err != nil {
+				return chirpFilter{}, errors.New("author_id is not a valid id: " + authorIDStr)
+			}
+			filter.AuthorIDs = append(filter.AuthorIDs, authorID)
+		}
+	}
+
+	var err error
+	if filter.Since, err = parseTimeParam(query.Get("since")); err != nil {
+		return chirpFilter{}, errors.New("since must be an RFC 3339 timestamp")
+	}
+	if filter.Until, err = parseTimeParam(query.Get("until")); err != nil {
+		return chirpFilter{}, errors.New("until must be an RFC 3339 timestamp")
+	}
+	if filter.Since.Valid && filter.Until.Valid && !filter.Since.Time.Before(filter.Until.Time) {
+		return chirpFilter{}, errors.New("since must be before until")
+	}
+
+	switch query.Get("sort") {
+	case "", "asc":
+	case "desc":
+		filter.Desc = true
+	default:
+		return chirpFilter{}, errors.New("sort must be asc or desc")
+	}
+
+	return filter, nil
+}
+
+func parseTimeParam(value string) (sql.NullTime, error) {
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	// Timestamps are stored without a zone, in UTC.
+	return sql.NullTime{Time: t.UTC(), Valid: true}, nil
+}
+
+// listChirps runs the filter as a single parameterized keyset query. It asks
+// for one row more than the page size so newChirpPage can tell whether there
+// is a next page without a COUNT.
+func (apiCfg *apiConfig) listChirps(r *http.Request, filter chirpFilter, page pageParams, viewerID uuid.NullUUID) ([]database.Chirp, error) {
+	params := database.ListChirpsAscParams{
+		AuthorIds:       filter.AuthorIDs,
+		Since:           filter.Since,
+		Until:           filter.Until,
+		ViewerID:        viewerID,
+		IncludeHidden:   apiCfg.isModerator(r.Context(), viewerID),
+		CursorCreatedAt: page.cursorCreatedAt(),
+		CursorID:        page.cursorID(),
+		PageLimit:       page.Limit + 1,
+	}
+	if filter.Desc {
+		return apiCfg.db.ListChirpsDesc(r.Context(), database.ListChirpsDescParams(params))
+	}
+	return apiCfg.db.ListChirpsAsc(r.Context(), params)
+}
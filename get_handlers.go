@@ -1,44 +1,49 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/LoronsoDev/chirpy/internal/auth"
+	"github.com/LoronsoDev/chirpy/internal/chirptext"
+	"github.com/LoronsoDev/chirpy/internal/cursor"
 	"github.com/LoronsoDev/chirpy/internal/database"
+	"github.com/LoronsoDev/chirpy/internal/mail"
+	"github.com/LoronsoDev/chirpy/internal/search"
 	"github.com/google/uuid"
 )
 
 func (apiConf *apiConfig) handlerGetAllChirps(w http.ResponseWriter, r *http.Request) {
-	authorID := r.URL.Query().Get("author_id")
-	sortDir := r.URL.Query().Get("sort")
-
-	var dbChirps []database.Chirp
-	var err error
+	filter, err := parseChirpFilter(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	if authorID != "" {
-		uniqueId, _ := uuid.Parse(authorID)
-		dbChirps, err = apiConf.db.GetChirpsFromUser(r.Context(), uniqueId)
-	} else {
-		if sortDir == "desc" {
-			dbChirps, err = apiConf.db.GetAllChirpsDescOrder(r.Context())
-		} else {
-			dbChirps, err = apiConf.db.GetAllChirpsAscOrder(r.Context())
-		}
+	page, err := apiConf.parsePageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
 	}
+
+	viewerID := viewerFromContext(r.Context())
+	dbChirps, err := apiConf.listChirps(r, filter, page, viewerID)
 	if err != nil {
 		respondWithError(w, http.StatusFailedDependency, err.Error())
-	}
-	chirps := []Chirp{}
-	for _, dbChirp := range dbChirps {
-		chirps = append(chirps, Chirp{
-			ID:        dbChirp.ID,
-			CreatedAt: dbChirp.CreatedAt,
-			UpdatedAt: dbChirp.UpdatedAt,
-			Body:      dbChirp.Body,
-			UserID:    dbChirp.UserID,
-		})
+		return
 	}
 
-	respondWithJSON(w, http.StatusOK, chirps)
+	res, err := apiConf.newChirpPage(w, r, dbChirps, page, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusFailedDependency, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, res)
 }
 
 func (apiConf *apiConfig) handlerGetSpecificChirp(w http.ResponseWriter, r *http.Request) {
@@ -56,13 +61,884 @@ func (apiConf *apiConfig) handlerGetSpecificChirp(w http.ResponseWriter, r *http
 		respondWithError(w, http.StatusNotFound, err.Error())
 		return
 	}
+	if dbChirp.DeletedAt.Valid {
+		respondWithError(w, http.StatusNotFound, "Chirp has been deleted")
+		return
+	}
 
-	chirp := Chirp{}
-	chirp.ID = dbChirp.ID
-	chirp.CreatedAt = dbChirp.CreatedAt
-	chirp.UpdatedAt = dbChirp.UpdatedAt
-	chirp.Body = dbChirp.Body
-	chirp.UserID = dbChirp.UserID
+	viewerID := viewerFromContext(r.Context())
+	if !apiConf.canSeeChirp(r.Context(), dbChirp, viewerID) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
 
+	chirp, err := apiConf.chirpResponse(r.Context(), dbChirp, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	respondWithJSON(w, http.StatusOK, chirp)
 }
+
+func (apiConf *apiConfig) handlerGetChirpRevisions(w http.ResponseWriter, r *http.Request) {
+	type revision struct {
+		ID         uuid.UUID `json:"id"`
+		ChirpID    uuid.UUID `json:"chirp_id"`
+		Body       string    `json:"body"`
+		CreatedAt  time.Time `json:"created_at"`
+		ReplacedAt time.Time `json:"replaced_at"`
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dbChirp, err := apiConf.db.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if dbChirp.DeletedAt.Valid {
+		respondWithError(w, http.StatusNotFound, "Chirp has been deleted")
+		return
+	}
+	if !apiConf.canSeeChirp(r.Context(), dbChirp, viewerFromContext(r.Context())) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+
+	dbRevisions, err := apiConf.db.GetChirpRevisions(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	revisions := []revision{}
+	for _, dbRevision := range dbRevisions {
+		revisions = append(revisions, revision{
+			ID:         dbRevision.ID,
+			ChirpID:    dbRevision.ChirpID,
+			Body:       dbRevision.Body,
+			CreatedAt:  dbRevision.CreatedAt,
+			ReplacedAt: dbRevision.ReplacedAt,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, revisions)
+}
+
+// threadNode is a chirp inside a conversation tree. Deleted chirps that still
+// have replies stay in the tree as tombstones so the conversation keeps its
+// shape.
+type threadNode struct {
+	Chirp
+	Depth      int           `json:"depth"`
+	ReplyCount int           `json:"reply_count"`
+	Replies    []*threadNode `json:"replies"`
+}
+
+func (apiConf *apiConfig) handlerGetThread(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dbChirp, err := apiConf.db.GetChirp(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	viewerID := viewerFromContext(r.Context())
+	if !apiConf.canSeeChirp(r.Context(), dbChirp, viewerID) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+
+	dbThread, err := apiConf.db.GetThread(r.Context(), database.GetThreadParams{
+		ThreadID:      dbChirp.ThreadID,
+		ViewerID:      viewerID,
+		IncludeHidden: apiConf.isModerator(r.Context(), viewerID),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	chirps, err := apiConf.chirpResponses(r.Context(), dbThread, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	root := buildThread(dbThread, chirps, dbChirp.ThreadID)
+	if root == nil {
+		respondWithError(w, http.StatusNotFound, "Thread not found")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, root)
+}
+
+// buildThread links the flat, chronologically ordered thread rows (and their
+// matching responses) into a tree rooted at rootID. Replies whose parent was
+// removed entirely, or is hidden from the viewer, are attached to the root so
+// they aren't lost.
+func buildThread(dbChirps []database.Chirp, chirps []Chirp, rootID uuid.UUID) *threadNode {
+	nodes := make(map[uuid.UUID]*threadNode, len(dbChirps))
+	for i, dbChirp := range dbChirps {
+		nodes[dbChirp.ID] = &threadNode{
+			Chirp:   chirps[i],
+			Replies: []*threadNode{},
+		}
+	}
+
+	root, ok := nodes[rootID]
+	if !ok {
+		return nil
+	}
+
+	for _, dbChirp := range dbChirps {
+		if dbChirp.ID == rootID {
+			continue
+		}
+		parent := root
+		if dbChirp.ReplyTo.Valid {
+			if node, ok := nodes[dbChirp.ReplyTo.UUID]; ok {
+				parent = node
+			}
+		}
+		parent.Replies = append(parent.Replies, nodes[dbChirp.ID])
+		parent.ReplyCount++
+	}
+
+	setThreadDepth(root, 0)
+	return root
+}
+
+func setThreadDepth(node *threadNode, depth int) {
+	node.Depth = depth
+	for _, reply := range node.Replies {
+		setThreadDepth(reply, depth+1)
+	}
+}
+
+func (apiCfg *apiConfig) handlerGetTimeline(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFromContext(r.Context())
+
+	page, err := apiCfg.parsePageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dbChirps, err := apiCfg.db.ListTimeline(r.Context(), database.ListTimelineParams{
+		FollowerID:      userID,
+		CursorCreatedAt: page.cursorCreatedAt(),
+		CursorID:        page.cursorID(),
+		PageLimit:       page.Limit + 1,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res, err := apiCfg.newChirpPage(w, r, dbChirps, page, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+type followUser struct {
+	ID         uuid.UUID `json:"id"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+func (apiCfg *apiConfig) handlerGetFollowers(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := apiCfg.db.GetUser(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	page, err := apiCfg.parsePageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dbFollowers, err := apiCfg.db.ListFollowers(r.Context(), database.ListFollowersParams{
+		FolloweeID:      userID,
+		CursorCreatedAt: page.cursorCreatedAt(),
+		CursorID:        page.cursorID(),
+		PageLimit:       page.Limit + 1,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	followers := []followUser{}
+	for _, dbFollower := range dbFollowers {
+		followers = append(followers, followUser(dbFollower))
+	}
+	respondWithJSON(w, http.StatusOK, apiCfg.newFollowPage(w, r, followers, page))
+}
+
+func (apiCfg *apiConfig) handlerGetFollowing(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := apiCfg.db.GetUser(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	page, err := apiCfg.parsePageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dbFollowing, err := apiCfg.db.ListFollowing(r.Context(), database.ListFollowingParams{
+		FollowerID:      userID,
+		CursorCreatedAt: page.cursorCreatedAt(),
+		CursorID:        page.cursorID(),
+		PageLimit:       page.Limit + 1,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	following := []followUser{}
+	for _, dbFollowee := range dbFollowing {
+		following = append(following, followUser(dbFollowee))
+	}
+	respondWithJSON(w, http.StatusOK, apiCfg.newFollowPage(w, r, following, page))
+}
+
+func (apiCfg *apiConfig) handlerGetTagChirps(w http.ResponseWriter, r *http.Request) {
+	tag := chirptext.NormalizeTag(r.PathValue("tag"))
+	if tag == "" {
+		respondWithError(w, http.StatusBadRequest, "Tag can't be empty")
+		return
+	}
+
+	page, err := apiCfg.parsePageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dbChirps, err := apiCfg.db.ListTagChirps(r.Context(), database.ListTagChirpsParams{
+		Tag:             tag,
+		CursorCreatedAt: page.cursorCreatedAt(),
+		CursorID:        page.cursorID(),
+		PageLimit:       page.Limit + 1,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res, err := apiCfg.newChirpPage(w, r, dbChirps, page, viewerFromContext(r.Context()))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+func (apiCfg *apiConfig) handlerGetTrendingTags(w http.ResponseWriter, r *http.Request) {
+	const (
+		defaultWindow = 24 * time.Hour
+		maxWindow     = 30 * 24 * time.Hour
+		defaultLimit  = 10
+		maxLimit      = 100
+	)
+	type trendingTag struct {
+		Tag  string `json:"tag"`
+		Uses int64  `json:"uses"`
+	}
+
+	window := defaultWindow
+	if windowStr := r.URL.Query().Get("window"); windowStr != "" {
+		parsed, err := time.ParseDuration(windowStr)
+		if err != nil || parsed <= 0 || parsed > maxWindow {
+			respondWithError(w, http.StatusBadRequest, "window must be a duration like 24h, up to 720h")
+			return
+		}
+		window = parsed
+	}
+
+	limit := defaultLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 1 || parsed > maxLimit {
+			respondWithError(w, http.StatusBadRequest, "limit must be a number between 1 and "+strconv.Itoa(maxLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	dbTags, err := apiCfg.db.GetTrendingTags(r.Context(), database.GetTrendingTagsParams{
+		Since:    time.Now().UTC().Add(-window),
+		TagLimit: int32(limit),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	tags := []trendingTag{}
+	for _, dbTag := range dbTags {
+		tags = append(tags, trendingTag(dbTag))
+	}
+	respondWithJSON(w, http.StatusOK, tags)
+}
+
+func (apiCfg *apiConfig) handlerGetNotifications(w http.ResponseWriter, r *http.Request) {
+	type notification struct {
+		ID        uuid.UUID  `json:"id"`
+		CreatedAt time.Time  `json:"created_at"`
+		Kind      string     `json:"kind"`
+		ActorID   uuid.UUID  `json:"actor_id"`
+		ChirpID   uuid.UUID  `json:"chirp_id"`
+		Read      bool       `json:"read"`
+		ReadAt    *time.Time `json:"read_at"`
+	}
+	type notificationPage struct {
+		Notifications []notification `json:"notifications"`
+		UnreadCount   int64          `json:"unread_count"`
+		NextCursor    *string        `json:"next_cursor"`
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	page, err := apiCfg.parsePageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dbNotifications, err := apiCfg.db.ListNotifications(r.Context(), database.ListNotificationsParams{
+		UserID:          userID,
+		UnreadOnly:      r.URL.Query().Get("unread") == "true",
+		CursorCreatedAt: page.cursorCreatedAt(),
+		CursorID:        page.cursorID(),
+		PageLimit:       page.Limit + 1,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	unread, err := apiCfg.db.CountUnreadNotifications(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := notificationPage{Notifications: []notification{}, UnreadCount: unread}
+	if len(dbNotifications) > int(page.Limit) {
+		dbNotifications = dbNotifications[:page.Limit]
+		last := dbNotifications[len(dbNotifications)-1]
+		next := apiCfg.setNextPageLink(w, r, cursor.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+		res.NextCursor = &next
+	}
+	for _, dbNotification := range dbNotifications {
+		n := notification{
+			ID:        dbNotification.ID,
+			CreatedAt: dbNotification.CreatedAt,
+			Kind:      dbNotification.Kind,
+			ActorID:   dbNotification.ActorID,
+			ChirpID:   dbNotification.ChirpID,
+			Read:      dbNotification.ReadAt.Valid,
+		}
+		if dbNotification.ReadAt.Valid {
+			n.ReadAt = &dbNotification.ReadAt.Time
+		}
+		res.Notifications = append(res.Notifications, n)
+	}
+
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+func (apiCfg *apiConfig) handlerSearchChirps(w http.ResponseWriter, r *http.Request) {
+	const maxOffset = 1000
+	type searchResult struct {
+		Chirp
+		Rank    float32 `json:"rank"`
+		Snippet string  `json:"snippet"`
+	}
+	type searchPage struct {
+		Results    []searchResult `json:"results"`
+		NextOffset *int           `json:"next_offset"`
+	}
+
+	tsquery, err := search.ParseQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter, err := parseChirpFilter(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := apiCfg.parsePageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Results are ordered by relevance, which doesn't make a stable keyset,
+	// so search pages by offset instead of cursor.
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 || offset > maxOffset {
+			respondWithError(w, http.StatusBadRequest, "offset must be a number between 0 and "+strconv.Itoa(maxOffset))
+			return
+		}
+	}
+
+	rows, err := apiCfg.db.SearchChirps(r.Context(), database.SearchChirpsParams{
+		Query:      tsquery,
+		AuthorIds:  filter.AuthorIDs,
+		Since:      filter.Since,
+		Until:      filter.Until,
+		PageLimit:  page.Limit + 1,
+		PageOffset: int32(offset),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := searchPage{Results: []searchResult{}}
+	if len(rows) > int(page.Limit) {
+		rows = rows[:page.Limit]
+		nextOffset := offset + int(page.Limit)
+		res.NextOffset = &nextOffset
+	}
+
+	dbChirps := make([]database.Chirp, 0, len(rows))
+	for _, row := range rows {
+		dbChirps = append(dbChirps, database.Chirp{
+			ID:        row.ID,
+			CreatedAt: row.CreatedAt,
+			UpdatedAt: row.UpdatedAt,
+			Body:      row.Body,
+			UserID:    row.UserID,
+			ReplyTo:   row.ReplyTo,
+			ThreadID:  row.ThreadID,
+			DeletedAt: row.DeletedAt,
+			RechirpOf: row.RechirpOf,
+			QuoteOf:   row.QuoteOf,
+		})
+	}
+	chirps, err := apiCfg.chirpResponses(r.Context(), dbChirps, viewerFromContext(r.Context()))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for i, row := range rows {
+		res.Results = append(res.Results, searchResult{
+			Chirp:   chirps[i],
+			Rank:    row.Rank,
+			Snippet: search.Highlight(row.Snippet),
+		})
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+// handlerGetReports is the moderators' review queue, oldest reports first.
+func (apiCfg *apiConfig) handlerGetReports(w http.ResponseWriter, r *http.Request) {
+	type reportPage struct {
+		Reports    []Report `json:"reports"`
+		NextCursor *string  `json:"next_cursor"`
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = "open"
+	case "open", "resolved", "dismissed":
+	default:
+		respondWithError(w, http.StatusBadRequest, "status must be open, resolved or dismissed")
+		return
+	}
+
+	page, err := apiCfg.parsePageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dbReports, err := apiCfg.db.ListReports(r.Context(), database.ListReportsParams{
+		Status:          status,
+		CursorCreatedAt: page.cursorCreatedAt(),
+		CursorID:        page.cursorID(),
+		PageLimit:       page.Limit + 1,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := reportPage{Reports: []Report{}}
+	if len(dbReports) > int(page.Limit) {
+		dbReports = dbReports[:page.Limit]
+		last := dbReports[len(dbReports)-1]
+		next := apiCfg.setNextPageLink(w, r, cursor.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+		res.NextCursor = &next
+	}
+
+	chirpIDs := make([]uuid.UUID, 0, len(dbReports))
+	for _, dbReport := range dbReports {
+		chirpIDs = append(chirpIDs, dbReport.ChirpID)
+	}
+	dbChirps, err := apiCfg.db.GetChirpsByIDs(r.Context(), chirpIDs)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	chirps := make(map[uuid.UUID]Chirp, len(dbChirps))
+	for _, dbChirp := range dbChirps {
+		chirps[dbChirp.ID] = newChirpResponse(dbChirp)
+	}
+
+	for _, dbReport := range dbReports {
+		report := newReportResponse(dbReport)
+		if chirp, ok := chirps[dbReport.ChirpID]; ok {
+			report.Chirp = &chirp
+		}
+		res.Reports = append(res.Reports, report)
+	}
+	respondWithJSON(w, http.StatusOK, res)
+}
+
+func (apiCfg *apiConfig) handlerGetSessions(w http.ResponseWriter, r *http.Request) {
+	type session struct {
+		ID         uuid.UUID  `json:"id"`
+		UserAgent  string     `json:"user_agent"`
+		IPAddress  string     `json:"ip_address"`
+		StartedAt  time.Time  `json:"started_at"`
+		LastUsedAt time.Time  `json:"last_used_at"`
+		ExpiresAt  *time.Time `json:"expires_at"`
+		Current    bool       `json:"current"`
+	}
+
+	claims, _ := claimsFromContext(r.Context())
+	dbSessions, err := apiCfg.db.ListSessions(r.Context(), userIDFromContext(r.Context()))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	sessions := []session{}
+	for _, dbSession := range dbSessions {
+		s := session{
+			ID:         dbSession.FamilyID,
+			UserAgent:  dbSession.UserAgent,
+			IPAddress:  dbSession.IpAddress,
+			StartedAt:  dbSession.StartedAt,
+			LastUsedAt: dbSession.LastUsedAt,
+			Current:    dbSession.FamilyID == claims.SessionID,
+		}
+		if dbSession.ExpiresAt.Valid {
+			s.ExpiresAt = &dbSession.ExpiresAt.Time
+		}
+		sessions = append(sessions, s)
+	}
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+// handlerGetAPIKeys lists the caller's API keys, revoked and expired ones
+// included, without the keys themselves.
+func (apiCfg *apiConfig) handlerGetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	dbKeys, err := apiCfg.db.ListUserAPIKeys(r.Context(), userIDFromContext(r.Context()))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	keys := make([]APIKey, 0, len(dbKeys))
+	for _, dbKey := range dbKeys {
+		keys = append(keys, newAPIKeyResponse(dbKey))
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+// handlerGetJWKS publishes the public keys access tokens can be verified with.
+func (apiCfg *apiConfig) handlerGetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, apiCfg.jwtKeys.JWKS())
+}
+
+// handlerVerifyEmail marks the address a verification link was sent to as
+// verified, as long as it's still the user's address.
+func (apiCfg *apiConfig) handlerVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing token")
+		return
+	}
+
+	var email string
+	err := apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		verification, err := qtx.GetEmailVerificationForUpdate(r.Context(), auth.HashRefreshToken(token))
+		if errors.Is(err, sql.ErrNoRows) {
+			return errInvalidVerificationToken
+		}
+		if err != nil {
+			return err
+		}
+		if verification.ExpiresAt.Before(time.Now()) {
+			return errInvalidVerificationToken
+		}
+
+		rows, err := qtx.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{
+			ID:    verification.UserID,
+			Email: verification.Email,
+		})
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return errInvalidVerificationToken
+		}
+		email = verification.Email
+		return qtx.DeleteUserEmailVerifications(r.Context(), verification.UserID)
+	})
+	if errors.Is(err, errInvalidVerificationToken) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, err := apiCfg.promoteAdmins(r.Context(), email); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}{
+		Email:         email,
+		EmailVerified: true,
+	})
+}
+
+// handlerGetOAuthClients lists the OAuth clients the caller registered.
+func (apiCfg *apiConfig) handlerGetOAuthClients(w http.ResponseWriter, r *http.Request) {
+	dbClients, err := apiCfg.db.ListUserOAuthClients(r.Context(), userIDFromContext(r.Context()))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	clients := make([]OAuthClient, 0, len(dbClients))
+	for _, dbClient := range dbClients {
+		clients = append(clients, newOAuthClientResponse(dbClient))
+	}
+	respondWithJSON(w, http.StatusOK, clients)
+}
+
+// handlerGetOAuthAuthorization checks an authorization request and tells the
+// frontend what to ask the user. consent_required is false when the user
+// already approved every requested scope for this client, and the frontend
+// can approve the request without asking again.
+func (apiCfg *apiConfig) handlerGetOAuthAuthorization(w http.ResponseWriter, r *http.Request) {
+	req, err := apiCfg.parseAuthorizationRequest(r.Context(), r.URL.Query())
+	if err != nil {
+		respondAuthorizationError(w, req, err)
+		return
+	}
+
+	consent, err := apiCfg.db.GetOAuthConsent(r.Context(), database.GetOAuthConsentParams{
+		UserID:   userIDFromContext(r.Context()),
+		ClientID: req.Client.ID,
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	type client struct {
+		ID   uuid.UUID `json:"id"`
+		Name string    `json:"name"`
+	}
+	respondWithJSON(w, http.StatusOK, struct {
+		Client          client   `json:"client"`
+		RedirectURI     string   `json:"redirect_uri"`
+		Scopes          []string `json:"scopes"`
+		ConsentRequired bool     `json:"consent_required"`
+	}{
+		Client:          client{ID: req.Client.ID, Name: req.Client.Name},
+		RedirectURI:     req.RedirectURI,
+		Scopes:          scopeNames(req.Scopes),
+		ConsentRequired: err != nil || !consentCovers(consent, req.Scopes),
+	})
+}
+
+// handlerGetOAuthConsents lists the apps the caller gave access to.
+func (apiCfg *apiConfig) handlerGetOAuthConsents(w http.ResponseWriter, r *http.Request) {
+	dbConsents, err := apiCfg.db.ListUserOAuthConsents(r.Context(), userIDFromContext(r.Context()))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	type consent struct {
+		ClientID   uuid.UUID `json:"client_id"`
+		ClientName string    `json:"client_name"`
+		Scopes     []string  `json:"scopes"`
+		CreatedAt  time.Time `json:"created_at"`
+		UpdatedAt  time.Time `json:"updated_at"`
+	}
+	consents := make([]consent, 0, len(dbConsents))
+	for _, c := range dbConsents {
+		consents = append(consents, consent{
+			ClientID:   c.ClientID,
+			ClientName: c.ClientName,
+			Scopes:     c.Scopes,
+			CreatedAt:  c.CreatedAt,
+			UpdatedAt:  c.UpdatedAt,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, consents)
+}
+
+// handlerStartOIDCLogin sends the browser to the identity provider to sign
+// in. The provider sends it back to handlerOIDCCallback.
+func (apiCfg *apiConfig) handlerStartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if apiCfg.oidcClient == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on isn't configured")
+		return
+	}
+
+	// The random tokens we use everywhere else are 64 hex characters,
+	// which also makes them valid PKCE code verifiers.
+	var values [3]string
+	for i := range values {
+		value, err := auth.MakeRefreshToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	err := apiCfg.db.CreateOIDCLogin(r.Context(), database.CreateOIDCLoginParams{
+		StateHash:    auth.HashRefreshToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().UTC().Add(oidcLoginTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	apiCfg.setOIDCStateCookie(w, state)
+	http.Redirect(w, r, apiCfg.oidcClient.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+// handlerOIDCCallback finishes signing in with the identity provider. The
+// user is linked or created from the ID token, then logged in the same way
+// as handlerLogin, two-factor authentication included.
+func (apiCfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if apiCfg.oidcClient == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on isn't configured")
+		return
+	}
+
+	login, err := apiCfg.takeOIDCLogin(r)
+	apiCfg.setOIDCStateCookie(w, "")
+	if errors.Is(err, errInvalidOIDCLogin) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		respondWithError(w, http.StatusUnauthorized, fmt.Sprintf("Sign-in failed: %s", providerErr))
+		return
+	}
+	rawIDToken, err := apiCfg.oidcClient.Exchange(r.Context(), query.Get("code"), login.CodeVerifier)
+	if err != nil {
+		log.Printf("Error redeeming OIDC authorization code: %v", err)
+		respondWithError(w, http.StatusBadGateway, "Couldn't complete sign-in with the identity provider")
+		return
+	}
+	idToken, err := apiCfg.oidcClient.VerifyIDToken(r.Context(), rawIDToken, login.Nonce)
+	if err != nil {
+		log.Printf("Error verifying OIDC ID token: %v", err)
+		respondWithError(w, http.StatusUnauthorized, "The identity provider's response couldn't be verified")
+		return
+	}
+
+	user, err := apiCfg.userForIdentity(r.Context(), apiCfg.oidcClient.Metadata().Issuer, idToken)
+	if errors.Is(err, errOIDCEmailNotVerified) || errors.Is(err, mail.ErrInvalidAddress) {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if errors.Is(err, errOIDCAccountUnverified) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if user.SuspendedAt.Valid {
+		respondWithError(w, http.StatusForbidden, errUserSuspended.Error())
+		return
+	}
+	// A new account's address is verified by the provider, so it may
+	// already be due to be an admin.
+	promoted, err := apiCfg.promoteAdmins(r.Context(), user.Email)
+	if err == nil && promoted > 0 {
+		user, err = apiCfg.db.GetUser(r.Context(), user.ID)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	totpEnabled, err := apiCfg.totpEnabled(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if totpEnabled {
+		apiCfg.respondWithMFAChallenge(w, r, user)
+		return
+	}
+	apiCfg.respondWithNewSession(w, r, user)
+}
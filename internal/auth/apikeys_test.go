@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestParseScopes(t *testing.T) {
+	parsed, err := ParseScopes([]string{"chirps:read", "chirps:write", "chirps:read"})
+	if err != nil {
+		t.Fatalf("ParseScopes() error = %v", err)
+	}
+	if len(parsed) != 2 || parsed[0] != ScopeChirpsRead || parsed[1] != ScopeChirpsWrite {
+		t.Errorf("unexpected scopes %v", parsed)
+	}
+
+	if _, err := ParseScopes([]string{"chirps:read", "admin"}); err == nil {
+		t.Error("expected an error for an unknown scope")
+	}
+	if parsed, err := ParseScopes(nil); err != nil || len(parsed) != 0 {
+		t.Errorf("expected no scopes, got %v (%v)", parsed, err)
+	}
+}
+
+func TestMakeAPIKey(t *testing.T) {
+	key, prefix, err := MakeAPIKey()
+	if err != nil {
+		t.Fatalf("MakeAPIKey() error = %v", err)
+	}
+	if !strings.HasPrefix(key, APIKeyPrefix) || !strings.HasPrefix(key, prefix) {
+		t.Errorf("unexpected key %q with prefix %q", key, prefix)
+	}
+	if len(prefix) >= len(key)/2 {
+		t.Errorf("the display prefix %q gives away too much of the key", prefix)
+	}
+
+	other, _, _ := MakeAPIKey()
+	if other == key {
+		t.Error("expected keys to be random")
+	}
+	if HashAPIKey(key) == key || HashAPIKey(key) != HashAPIKey(key+" ") || HashAPIKey(key) == HashAPIKey(other) {
+		t.Error("unexpected hashes")
+	}
+}
+
+func TestClaimsAllows(t *testing.T) {
+	userID := uuid.New()
+	claims := APIKeyClaims(userID, []Scope{ScopeChirpsRead})
+
+	if id, err := claims.UserID(); err != nil || id != userID {
+		t.Errorf("expected user %v, got %v (%v)", userID, id, err)
+	}
+	if !claims.Scoped() || claims.Role != RoleUser {
+		t.Errorf("expected scoped plain user claims, got %+v", claims)
+	}
+	if !claims.Allows(ScopeChirpsRead) || claims.Allows(ScopeChirpsWrite) {
+		t.Errorf("expected only %q to be allowed by %q", ScopeChirpsRead, claims.Scope)
+	}
+
+	unscoped := Claims{}
+	if unscoped.Scoped() || !unscoped.Allows(ScopeChirpsWrite) {
+		t.Error("claims without a scope should allow everything")
+	}
+}
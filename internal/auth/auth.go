@@ -2,11 +2,11 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
@@ -29,19 +29,61 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	expiresAt := time.Now().Add(expiresIn)
+// Claims are the contents of an access token.
+type Claims struct {
+	jwt.RegisteredClaims
+	Role Role `json:"role,omitempty"`
+	// SessionID is the refresh token family the token was issued from.
+	SessionID uuid.UUID `json:"sid,omitempty"`
+	// TokenVersion must match the user's current version; bumping it
+	// invalidates every access token issued before.
+	TokenVersion int32 `json:"ver"`
+	// Scope limits what the credentials can be used for, as a space
+	// separated list. Password logins have none and can do anything the
+	// user can; scoped credentials always have at least one.
+	Scope string `json:"scope,omitempty"`
+	// ClientID is the OAuth client the token was issued to, if any. Its
+	// SessionID is then the grant the user gave that client.
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// UserID is the user the token was issued to.
+func (c Claims) UserID() (uuid.UUID, error) {
+	return uuid.Parse(c.Subject)
+}
+
+// Scoped reports whether the claims are limited to their Scope.
+func (c Claims) Scoped() bool {
+	return c.Scope != ""
+}
+
+// Allows reports whether the claims can be used for something needing scope.
+func (c Claims) Allows(scope Scope) bool {
+	if !c.Scoped() {
+		return true
+	}
+	for _, s := range strings.Fields(c.Scope) {
+		if Scope(s) == scope {
+			return true
+		}
+	}
+	return false
+}
 
-	// Create the token with the specified claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		ExpiresAt: jwt.NewNumericDate(expiresAt), // Set expiration time
-		Subject:   userID.String(),
-	})
-	jwtSecret := os.Getenv("JWT_SECRET")
+// TokenSubject is who an access token is issued to.
+type TokenSubject struct {
+	UserID       uuid.UUID
+	Role         Role
+	SessionID    uuid.UUID
+	TokenVersion int32
+	// Scope and ClientID are only set for tokens issued to OAuth clients.
+	Scope    string
+	ClientID string
+}
 
-	return token.SignedString([]byte(jwtSecret))
+// MakeJWT issues an HS256 access token signed with tokenSecret.
+func MakeJWT(subject TokenSubject, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return NewHMACKeyManager(tokenSecret).MakeJWT(subject, expiresIn)
 }
 
 func MakeRefreshToken() (string, error) {
@@ -57,25 +99,36 @@ func MakeRefreshToken() (string, error) {
 	return randString, err
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	type Claims struct {
-		jwt.RegisteredClaims
-	}
+// HashRefreshToken is how refresh tokens are stored, so a leaked database
+// doesn't hand out working tokens. Tokens are random, so a plain SHA-256 is
+// enough; there is nothing to brute force.
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
 
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(tokenSecret), nil
-	})
+// ErrTokenRevoked is returned by claim checks for tokens that are well
+// formed and unexpired but were invalidated server side.
+var ErrTokenRevoked = errors.New("token has been revoked")
 
-	if err != nil || !token.Valid {
-		return uuid.Nil, err
-	}
+// ClaimCheck is an extra validation run on a token's claims once its
+// signature and expiry are verified, for things only the caller can know,
+// like the user's current token version.
+type ClaimCheck func(Claims) error
 
-	claims, ok := token.Claims.(*Claims)
-	if !ok {
-		return uuid.Nil, errors.New("invalid claims")
+// ParseJWT verifies an HS256 token signed with tokenSecret and returns its
+// claims.
+func ParseJWT(tokenString, tokenSecret string, checks ...ClaimCheck) (Claims, error) {
+	return NewHMACKeyManager(tokenSecret).ParseJWT(tokenString, checks...)
+}
+
+func ValidateJWT(tokenString, tokenSecret string, checks ...ClaimCheck) (uuid.UUID, error) {
+	claims, err := ParseJWT(tokenString, tokenSecret, checks...)
+	if err != nil {
+		return uuid.Nil, err
 	}
 
-	userID, err := uuid.Parse(claims.Subject)
+	userID, err := claims.UserID()
 	if err != nil {
 		return uuid.Nil, err
 	}
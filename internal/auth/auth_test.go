@@ -1,8 +1,8 @@
 package auth
 
 import (
+	"errors"
 	"net/http"
-	"os"
 	"testing"
 	"time"
 
@@ -13,13 +13,11 @@ func TestMakeAndValidateJWT(t *testing.T) {
 	new_uuid := uuid.New()
 	secret := "secret"
 	expiresIn := 15 * time.Second
-	tokenString, err := MakeJWT(new_uuid, secret, expiresIn)
+	tokenString, err := MakeJWT(TokenSubject{UserID: new_uuid, Role: RoleUser}, secret, expiresIn)
 	if err != nil {
 		t.Errorf("MakeJWT() error = %v", err)
 	}
-	jwtSecret := os.Getenv("JWT_SECRET")
-
-	id, err := ValidateJWT(tokenString, jwtSecret)
+	id, err := ValidateJWT(tokenString, secret)
 
 	if id != new_uuid {
 		t.Errorf("id was not verified correctly: %v != %v", new_uuid, id)
@@ -37,15 +35,158 @@ func TestMakeAndValidateJWT(t *testing.T) {
 	new_uuid = uuid.New()
 	secret = "secret"
 	expiresIn = 0
-	tokenString, _ = MakeJWT(new_uuid, secret, expiresIn)
+	tokenString, _ = MakeJWT(TokenSubject{UserID: new_uuid, Role: RoleUser}, secret, expiresIn)
 
-	_, err = ValidateJWT(tokenString, jwtSecret)
+	_, err = ValidateJWT(tokenString, secret)
 	if err == nil {
 		t.Error("Token is expired but it is still being verified as valid")
 	}
 
 }
 
+func TestParseJWTRole(t *testing.T) {
+	secret := "secret"
+
+	userID := uuid.New()
+	tokenString, err := MakeJWT(TokenSubject{UserID: userID, Role: RoleModerator}, secret, time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT() error = %v", err)
+	}
+
+	claims, err := ParseJWT(tokenString, secret)
+	if err != nil {
+		t.Fatalf("ParseJWT() error = %v", err)
+	}
+	if claims.Role != RoleModerator {
+		t.Errorf("expected role %q, got %q", RoleModerator, claims.Role)
+	}
+	if id, err := claims.UserID(); err != nil || id != userID {
+		t.Errorf("expected user id %v, got %v (err %v)", userID, id, err)
+	}
+
+	tokenString, _ = MakeJWT(TokenSubject{UserID: userID}, secret, time.Minute)
+	claims, err = ParseJWT(tokenString, secret)
+	if err != nil {
+		t.Fatalf("ParseJWT() error = %v", err)
+	}
+	if claims.Role != RoleUser {
+		t.Errorf("tokens without a role should default to %q, got %q", RoleUser, claims.Role)
+	}
+}
+
+func TestValidateJWTClaimChecks(t *testing.T) {
+	secret := "secret"
+
+	userID := uuid.New()
+	sessionID := uuid.New()
+	tokenString, err := MakeJWT(TokenSubject{UserID: userID, SessionID: sessionID, TokenVersion: 2}, secret, time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT() error = %v", err)
+	}
+
+	claims, err := ParseJWT(tokenString, secret)
+	if err != nil {
+		t.Fatalf("ParseJWT() error = %v", err)
+	}
+	if claims.SessionID != sessionID || claims.TokenVersion != 2 {
+		t.Errorf("expected session %v and version 2, got %v and %d", sessionID, claims.SessionID, claims.TokenVersion)
+	}
+
+	currentVersion := func(version int32) ClaimCheck {
+		return func(c Claims) error {
+			if c.TokenVersion != version {
+				return ErrTokenRevoked
+			}
+			return nil
+		}
+	}
+
+	if id, err := ValidateJWT(tokenString, secret, currentVersion(2)); err != nil || id != userID {
+		t.Errorf("expected token to pass its checks, got id %v and error %v", id, err)
+	}
+	if _, err := ValidateJWT(tokenString, secret, currentVersion(3)); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("expected ErrTokenRevoked for an outdated token version, got %v", err)
+	}
+}
+
+func TestMakeJWTForClient(t *testing.T) {
+	secret := "secret"
+	userID := uuid.New()
+	grantID := uuid.New()
+	tokenString, err := MakeJWT(TokenSubject{
+		UserID:    userID,
+		SessionID: grantID,
+		Scope:     JoinScopes([]Scope{ScopeChirpsRead}),
+		ClientID:  "client",
+	}, secret, time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT() error = %v", err)
+	}
+
+	if id, err := ValidateJWT(tokenString, secret); err != nil || id != userID {
+		t.Fatalf("expected client tokens to validate like any other, got %v (%v)", id, err)
+	}
+	claims, err := ParseJWT(tokenString, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.ClientID != "client" || claims.SessionID != grantID {
+		t.Errorf("unexpected client claims %+v", claims)
+	}
+	if !claims.Scoped() || !claims.Allows(ScopeChirpsRead) || claims.Allows(ScopeChirpsWrite) {
+		t.Errorf("expected the token to be limited to %q, got %q", ScopeChirpsRead, claims.Scope)
+	}
+}
+
+func TestRoleIncludes(t *testing.T) {
+	tests := []struct {
+		role     Role
+		required Role
+		expected bool
+	}{
+		{RoleAdmin, RoleModerator, true},
+		{RoleAdmin, RoleAdmin, true},
+		{RoleModerator, RoleModerator, true},
+		{RoleModerator, RoleAdmin, false},
+		{RoleUser, RoleModerator, false},
+		{RoleUser, RoleUser, true},
+		{Role("root"), RoleUser, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.role.Includes(tt.required); got != tt.expected {
+			t.Errorf("%q.Includes(%q) = %v, expected %v", tt.role, tt.required, got, tt.expected)
+		}
+	}
+
+	if _, err := ParseRole("root"); err == nil {
+		t.Error("expected an error for an unknown role")
+	}
+}
+
+func TestHashRefreshToken(t *testing.T) {
+	token, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("MakeRefreshToken() error = %v", err)
+	}
+
+	hash := HashRefreshToken(token)
+	if hash == token {
+		t.Error("hash should differ from the token")
+	}
+	if len(hash) != 64 {
+		t.Errorf("expected a hex encoded SHA-256 hash, got %q", hash)
+	}
+	if HashRefreshToken(token) != hash {
+		t.Error("hashing the same token twice should give the same hash")
+	}
+
+	other, _ := MakeRefreshToken()
+	if HashRefreshToken(other) == hash {
+		t.Error("different tokens should have different hashes")
+	}
+}
+
 func TestGetBearerToken(t *testing.T) {
 	tests := []struct {
 		name          string
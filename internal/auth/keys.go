@@ -0,0 +1,362 @@
+package auth
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSAKeyBits is the smallest RSA key accepted for signing or verifying.
+const minRSAKeyBits = 2048
+
+var (
+	// ErrUnknownKey is returned for tokens whose kid isn't one of ours.
+	ErrUnknownKey = errors.New("token was signed with an unknown key")
+	// ErrNoSigningKey is returned when a key manager can only verify.
+	ErrNoSigningKey = errors.New("no signing key configured")
+)
+
+// Key is a JWT signing or verification key, identified in tokens by the
+// kid header.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// signKey is nil for keys that can only verify, like the public half of
+	// a key that was rotated out.
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// CanSign reports whether the key has its private half.
+func (k *Key) CanSign() bool {
+	return k.signKey != nil
+}
+
+// KeyManager signs access tokens with its active key and verifies them with
+// any key it knows, so keys can be rotated without logging everyone out:
+// add the new key, make it active, and drop the old one once the tokens it
+// signed have expired.
+type KeyManager struct {
+	active    *Key
+	keys      map[string]*Key
+	validator Validator
+}
+
+// NewKeyManager builds a key manager that signs with the key named activeID.
+func NewKeyManager(keys []*Key, activeID string) (*KeyManager, error) {
+	km := &KeyManager{
+		keys:      make(map[string]*Key, len(keys)),
+		validator: Validator{Issuer: DefaultIssuer},
+	}
+	for _, key := range keys {
+		if _, ok := km.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		km.keys[key.ID] = key
+	}
+
+	active, ok := km.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active key %q not found", activeID)
+	}
+	if !active.CanSign() {
+		return nil, fmt.Errorf("active key %q has no private key", activeID)
+	}
+	km.active = active
+	return km, nil
+}
+
+// NewHMACKeyManager signs and verifies HS256 tokens with a shared secret.
+// Its tokens carry no kid, and it publishes nothing in the JWKS.
+func NewHMACKeyManager(secret string) *KeyManager {
+	key := &Key{
+		Method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}
+	return &KeyManager{
+		active:    key,
+		keys:      map[string]*Key{"": key},
+		validator: Validator{Issuer: DefaultIssuer},
+	}
+}
+
+// LoadKeyManager reads every .pem file in dir as a key named after the file,
+// so keys/2024-06.pem has kid "2024-06". Private keys can sign and verify;
+// public keys only verify. activeID picks the signing key and may be empty
+// when there is exactly one private key.
+func LoadKeyManager(dir, activeID string) (*KeyManager, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	keys := []*Key{}
+	signers := []string{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := ParseKeyPEM(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+		if key.CanSign() {
+			signers = append(signers, kid)
+		}
+	}
+
+	if activeID == "" {
+		if len(signers) != 1 {
+			return nil, fmt.Errorf("found %d private keys in %s, set the active key id", len(signers), dir)
+		}
+		activeID = signers[0]
+	}
+	return NewKeyManager(keys, activeID)
+}
+
+// ParseKeyPEM reads an RSA or Ed25519 key, private (PKCS#1 or PKCS#8) or
+// public (PKIX or PKCS#1).
+func ParseKeyPEM(kid string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.signKey, key.verifyKey = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.verifyKey = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.signKey, key.verifyKey = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.verifyKey = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	if pub, ok := key.verifyKey.(*rsa.PublicKey); ok && pub.N.BitLen() < minRSAKeyBits {
+		return nil, fmt.Errorf("RSA keys must be at least %d bits", minRSAKeyBits)
+	}
+	return key, nil
+}
+
+// MakeJWT issues an access token signed with the active key.
+func (km *KeyManager) MakeJWT(subject TokenSubject, expiresIn time.Duration) (string, error) {
+	if km.active == nil {
+		return "", ErrNoSigningKey
+	}
+	expiresAt := time.Now().Add(expiresIn)
+
+	var audience jwt.ClaimStrings
+	if km.validator.Audience != "" {
+		audience = jwt.ClaimStrings{km.validator.Audience}
+	}
+
+	// Create the token with the specified claims
+	token := jwt.NewWithClaims(km.active.Method, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    km.validator.Issuer,
+			Audience:  audience,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt), // Set expiration time
+			Subject:   subject.UserID.String(),
+		},
+		Role:         subject.Role,
+		SessionID:    subject.SessionID,
+		TokenVersion: subject.TokenVersion,
+		Scope:        subject.Scope,
+		ClientID:     subject.ClientID,
+	})
+	if km.active.ID != "" {
+		token.Header["kid"] = km.active.ID
+	}
+
+	return token.SignedString(km.active.signKey)
+}
+
+// ParseJWT verifies a token against the manager's Validator and returns its
+// claims. Errors wrap one of the ErrToken values. Tokens issued before roles
+// existed carry no role claim and are treated as plain users.
+func (km *KeyManager) ParseJWT(tokenString string, checks ...ClaimCheck) (Claims, error) {
+	token, err := km.validator.parser().ParseWithClaims(tokenString, &Claims{}, km.keyFunc)
+	if err != nil {
+		return Claims{}, tokenError(err)
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return Claims{}, ErrTokenMalformed
+	}
+	if err := km.validator.checkClaims(claims); err != nil {
+		return Claims{}, err
+	}
+	if claims.Role == "" {
+		claims.Role = RoleUser
+	}
+
+	for _, check := range checks {
+		if err := check(*claims); err != nil {
+			return Claims{}, err
+		}
+	}
+	return *claims, nil
+}
+
+// keyFunc picks the verification key named by the token's kid. Each key only
+// accepts its own algorithm, so a token can't claim HS256 and get checked
+// against an RSA public key used as an HMAC secret.
+func (km *KeyManager) keyFunc(token *jwt.Token) (interface{}, error) {
+	if !km.validator.allows(token.Method.Alg()) {
+		return nil, fmt.Errorf("%w: %q", ErrTokenAlgorithm, token.Method.Alg())
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, ok := km.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("%w: %q for key %q", ErrTokenAlgorithm, token.Method.Alg(), kid)
+	}
+	return key.verifyKey, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519 and elliptic curve keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	// Elliptic curve keys
+	Y string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public keys that can verify our tokens, sorted by id.
+// Shared secrets are never published.
+func (km *KeyManager) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range km.keys {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+	return set
+}
+
+// PublicKey decodes an RSA, Ed25519 or P-256 public key, for verifying
+// tokens signed by someone else, like an OpenID Connect provider.
+func (k JWK) PublicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		pub := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if pub.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA keys must be at least %d bits", minRSAKeyBits)
+		}
+		return pub, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 key")
+		}
+		// ecdh rejects points that aren't on the curve.
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("invalid P-256 key: %w", err)
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newKeysDir writes an RSA private key "rsa-1", an Ed25519 private key
+// "ed-1" and the public half of an Ed25519 key "ed-0".
+func newKeysDir(t *testing.T) (string, ed25519.PrivateKey) {
+	t.Helper()
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, dir, "rsa-1.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, dir, "ed-1.pem", "PRIVATE KEY", der)
+
+	oldPub, oldKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, err = x509.MarshalPKIXPublicKey(oldPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, dir, "ed-0.pem", "PUBLIC KEY", der)
+
+	return dir, oldKey
+}
+
+func TestKeyManagerSignAndVerify(t *testing.T) {
+	dir, _ := newKeysDir(t)
+
+	for _, tt := range []struct {
+		kid string
+		alg string
+	}{
+		{kid: "rsa-1", alg: "RS256"},
+		{kid: "ed-1", alg: "EdDSA"},
+	} {
+		t.Run(tt.kid, func(t *testing.T) {
+			km, err := LoadKeyManager(dir, tt.kid)
+			if err != nil {
+				t.Fatalf("LoadKeyManager() error = %v", err)
+			}
+
+			userID := uuid.New()
+			tokenString, err := km.MakeJWT(TokenSubject{UserID: userID, Role: RoleAdmin}, time.Minute)
+			if err != nil {
+				t.Fatalf("MakeJWT() error = %v", err)
+			}
+
+			token, _, err := jwt.NewParser().ParseUnverified(tokenString, &Claims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.Header["kid"] != tt.kid || token.Header["alg"] != tt.alg {
+				t.Errorf("expected kid %q and alg %q, got header %v", tt.kid, tt.alg, token.Header)
+			}
+
+			claims, err := km.ParseJWT(tokenString)
+			if err != nil {
+				t.Fatalf("ParseJWT() error = %v", err)
+			}
+			if id, _ := claims.UserID(); id != userID || claims.Role != RoleAdmin {
+				t.Errorf("unexpected claims: %+v", claims)
+			}
+		})
+	}
+}
+
+func TestKeyManagerRotation(t *testing.T) {
+	dir, _ := newKeysDir(t)
+
+	before, err := LoadKeyManager(dir, "rsa-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenString, err := before.MakeJWT(TokenSubject{UserID: uuid.New()}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := LoadKeyManager(dir, "ed-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := after.ParseJWT(tokenString); err != nil {
+		t.Errorf("tokens signed with the previous key should still verify, got %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "rsa-1.pem")); err != nil {
+		t.Fatal(err)
+	}
+	retired, err := LoadKeyManager(dir, "")
+	if err != nil {
+		t.Fatalf("LoadKeyManager() with one private key left error = %v", err)
+	}
+	if _, err := retired.ParseJWT(tokenString); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey once the key is removed, got %v", err)
+	}
+}
+
+func TestKeyManagerVerifyOnlyKeys(t *testing.T) {
+	dir, oldKey := newKeysDir(t)
+
+	km, err := LoadKeyManager(dir, "ed-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A token signed before ed-0 was retired and only its public half kept.
+	old := &Key{ID: "ed-0", Method: jwt.SigningMethodEdDSA, signKey: oldKey, verifyKey: oldKey.Public()}
+	oldKM, err := NewKeyManager([]*Key{old}, "ed-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenString, err := oldKM.MakeJWT(TokenSubject{UserID: uuid.New()}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := km.ParseJWT(tokenString); err != nil {
+		t.Errorf("expected token signed with a retired key to verify, got %v", err)
+	}
+
+	if _, err := LoadKeyManager(dir, "ed-0"); err == nil {
+		t.Error("a public key shouldn't be usable as the active key")
+	}
+	if _, err := LoadKeyManager(dir, ""); err == nil {
+		t.Error("expected an error when several private keys are found and none is chosen")
+	}
+}
+
+func TestKeyManagerRejectsAlgorithmConfusion(t *testing.T) {
+	dir, _ := newKeysDir(t)
+	km, err := LoadKeyManager(dir, "rsa-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sign an HS256 token using the RSA public key as the HMAC secret.
+	pubPEM, err := os.ReadFile(filepath.Join(dir, "rsa-1.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	})
+	token.Header["kid"] = "rsa-1"
+	forged, err := token.SignedString(pubPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := km.ParseJWT(forged); err == nil {
+		t.Error("an HS256 token must not verify against an RSA key")
+	}
+
+	// Tokens without a kid aren't accepted by asymmetric key managers.
+	unsigned, _ := MakeJWT(TokenSubject{UserID: uuid.New()}, "secret", time.Minute)
+	if _, err := km.ParseJWT(unsigned); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey for a token without kid, got %v", err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	dir, _ := newKeysDir(t)
+	km, err := LoadKeyManager(dir, "rsa-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := km.JWKS()
+	if len(set.Keys) != 3 {
+		t.Fatalf("expected 3 keys, got %d", len(set.Keys))
+	}
+
+	byID := map[string]JWK{}
+	for _, jwk := range set.Keys {
+		byID[jwk.Kid] = jwk
+		if jwk.Use != "sig" {
+			t.Errorf("expected use sig for %q, got %q", jwk.Kid, jwk.Use)
+		}
+	}
+	if rsaKey := byID["rsa-1"]; rsaKey.Kty != "RSA" || rsaKey.Alg != "RS256" || rsaKey.N == "" || rsaKey.E != "AQAB" {
+		t.Errorf("unexpected RSA JWK: %+v", rsaKey)
+	}
+	for _, kid := range []string{"ed-0", "ed-1"} {
+		if edKey := byID[kid]; edKey.Kty != "OKP" || edKey.Crv != "Ed25519" || edKey.Alg != "EdDSA" || edKey.X == "" {
+			t.Errorf("unexpected Ed25519 JWK: %+v", edKey)
+		}
+	}
+
+	if keys := NewHMACKeyManager("secret").JWKS().Keys; len(keys) != 0 {
+		t.Errorf("shared secrets must not be published, got %v", keys)
+	}
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	dir, _ := newKeysDir(t)
+	km, err := LoadKeyManager(dir, "rsa-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, jwk := range km.JWKS().Keys {
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatalf("%s: %v", jwk.Kid, err)
+		}
+		verifyKey := km.keys[jwk.Kid].verifyKey
+		equal, ok := verifyKey.(interface{ Equal(crypto.PublicKey) bool })
+		if !ok || !equal.Equal(pub) {
+			t.Errorf("%s: decoded key doesn't match", jwk.Kid)
+		}
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecJWK := JWK{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))),
+	}
+	if pub, err := ecJWK.PublicKey(); err != nil || !ecKey.PublicKey.Equal(pub) {
+		t.Errorf("expected the P-256 key to round trip, got %v", err)
+	}
+	ecJWK.Y = ecJWK.X
+	if _, err := ecJWK.PublicKey(); err == nil {
+		t.Error("expected a point off the curve to be rejected")
+	}
+
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallJWK := JWK{
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(small.N.Bytes()),
+		E:   "AQAB",
+	}
+	if _, err := smallJWK.PublicKey(); err == nil {
+		t.Error("expected small RSA keys to be rejected")
+	}
+	if _, err := (JWK{Kty: "oct"}).PublicKey(); err == nil {
+		t.Error("expected symmetric keys to be rejected")
+	}
+}
+
+func TestParseKeyPEMRejectsSmallRSAKeys(t *testing.T) {
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(small)})
+	if _, err := ParseKeyPEM("small", data); err == nil || !strings.Contains(err.Error(), "2048") {
+		t.Errorf("expected small RSA keys to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	// The example from RFC 7636 appendix B.
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if got := PKCEChallenge(verifier); got != challenge {
+		t.Errorf("PKCEChallenge() = %q, expected %q", got, challenge)
+	}
+	if !VerifyPKCE(verifier, challenge) {
+		t.Error("expected the RFC example to verify")
+	}
+
+	tests := []struct {
+		name     string
+		verifier string
+	}{
+		{"wrong verifier", strings.Replace(verifier, "d", "e", 1)},
+		{"plain method", challenge},
+		{"too short", "abc"},
+		{"too long", strings.Repeat("a", 129)},
+		{"invalid characters", strings.Repeat("a", 42) + "!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if VerifyPKCE(tt.verifier, challenge) {
+				t.Errorf("expected %q to be rejected", tt.verifier)
+			}
+		})
+	}
+}
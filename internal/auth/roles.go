@@ -0,0 +1,38 @@
+package auth
+
+import "fmt"
+
+// Role is what a user is allowed to do. Each role includes the ones below it.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// ParseRole validates a role name.
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := roleRanks[role]; !ok {
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+	return role, nil
+}
+
+// Includes reports whether r grants at least the permissions of other.
+// Unknown roles include nothing.
+func (r Role) Includes(other Role) bool {
+	rank, ok := roleRanks[r]
+	if !ok {
+		return false
+	}
+	otherRank, ok := roleRanks[other]
+	return ok && rank >= otherRank
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors.
+var rfc6238Secret = []byte("12345678901234567890")
+
+func TestHOTPRFC6238Vectors(t *testing.T) {
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		if got := hotp(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)), 8); got != tt.expected {
+			t.Errorf("hotp at %d = %s, expected %s", tt.unix, got, tt.expected)
+		}
+	}
+}
+
+func TestTOTPCodeAndValidate(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(rfc6238Secret)
+	now := time.Unix(59, 0)
+
+	code, err := TOTPCode(secret, now)
+	if err != nil {
+		t.Fatalf("TOTPCode() error = %v", err)
+	}
+	if code != "287082" {
+		t.Errorf("expected the last 6 digits of the RFC vector, got %s", code)
+	}
+
+	step, ok := ValidateTOTP(secret, code, now, 0)
+	if !ok || step != TOTPStep(now) {
+		t.Fatalf("expected code to validate at step %d, got %d, %v", TOTPStep(now), step, ok)
+	}
+	if _, ok := ValidateTOTP(secret, code, now, step); ok {
+		t.Error("a code shouldn't be accepted twice")
+	}
+	if _, ok := ValidateTOTP(secret, code, now.Add(totpPeriod), 0); !ok {
+		t.Error("expected the previous period's code to be accepted")
+	}
+	if _, ok := ValidateTOTP(secret, code, now.Add(3*totpPeriod), 0); ok {
+		t.Error("expected codes from several periods ago to be rejected")
+	}
+	if _, ok := ValidateTOTP(secret, "123456", now, 0); ok {
+		t.Error("expected a wrong code to be rejected")
+	}
+	if _, ok := ValidateTOTP("not base32!", code, now, 0); ok {
+		t.Error("expected an invalid secret to be rejected")
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret() error = %v", err)
+	}
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(key) != totpSecretBytes {
+		t.Fatalf("expected %d bytes of base32, got %q (%v)", totpSecretBytes, secret, err)
+	}
+
+	code, _ := TOTPCode(secret, time.Now())
+	if _, ok := ValidateTOTP(secret, code, time.Now(), 0); !ok {
+		t.Error("expected a fresh secret's code to validate")
+	}
+
+	uri, err := url.Parse(TOTPURI(secret, "Chirpy", "walt@breakingbad.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Chirpy:walt@breakingbad.com" {
+		t.Errorf("unexpected URI %s", uri)
+	}
+	if uri.Query().Get("secret") != secret || uri.Query().Get("issuer") != "Chirpy" {
+		t.Errorf("unexpected URI parameters %v", uri.Query())
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := MakeRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("MakeRecoveryCodes() error = %v", err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("expected 10 codes, got %d", len(codes))
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 19 || strings.Count(code, "-") != 3 {
+			t.Errorf("unexpected code format %q", code)
+		}
+		if seen[code] {
+			t.Errorf("duplicate code %q", code)
+		}
+		seen[code] = true
+	}
+
+	code := codes[0]
+	typed := strings.ToUpper(strings.ReplaceAll(code, "-", " "))
+	if HashRecoveryCode(typed) != HashRecoveryCode(code) {
+		t.Error("expected case, spaces and dashes to be ignored")
+	}
+	if HashRecoveryCode(codes[1]) == HashRecoveryCode(code) {
+		t.Error("expected different codes to hash differently")
+	}
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultIssuer is the iss claim of the tokens chirpy issues.
+const DefaultIssuer = "chirpy"
+
+// Errors returned when an access token is rejected. They wrap the underlying
+// jwt error, so callers can tell why with errors.Is and still log the detail.
+var (
+	ErrTokenMalformed   = errors.New("token is malformed")
+	ErrTokenSignature   = errors.New("token signature is invalid")
+	ErrTokenAlgorithm   = errors.New("token signing algorithm is not allowed")
+	ErrTokenExpired     = errors.New("token has expired")
+	ErrTokenNotValidYet = errors.New("token is not valid yet")
+	ErrTokenIssuer      = errors.New("token has the wrong issuer")
+	ErrTokenAudience    = errors.New("token has the wrong audience")
+)
+
+// Validator is how strictly a key manager checks the tokens it verifies.
+// The same settings are used when issuing tokens, so both sides agree on
+// the iss and aud claims.
+type Validator struct {
+	// Issuer is required in the iss claim. Defaults to DefaultIssuer.
+	Issuer string
+	// Audience is required in the aud claim when set.
+	Audience string
+	// Leeway is the clock skew tolerated on exp, nbf and iat.
+	Leeway time.Duration
+	// Algorithms are the signing algorithms accepted, on top of each key
+	// only accepting its own. Empty allows the algorithms of known keys.
+	Algorithms []string
+}
+
+// SetValidator changes how tokens are issued and checked. The active key's
+// algorithm must be allowed, or the manager couldn't verify its own tokens.
+func (km *KeyManager) SetValidator(v Validator) error {
+	if v.Issuer == "" {
+		v.Issuer = DefaultIssuer
+	}
+	if km.active != nil && !v.allows(km.active.Method.Alg()) {
+		return fmt.Errorf("active key algorithm %q is not in the allowed algorithms", km.active.Method.Alg())
+	}
+	km.validator = v
+	return nil
+}
+
+func (v Validator) allows(alg string) bool {
+	return len(v.Algorithms) == 0 || slices.Contains(v.Algorithms, alg)
+}
+
+func (v Validator) parser() *jwt.Parser {
+	return jwt.NewParser(
+		jwt.WithLeeway(v.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+}
+
+// checkClaims verifies the claims the jwt parser leaves to us. Doing it here
+// rather than with parser options lets a missing claim and a wrong one
+// report the same error.
+func (v Validator) checkClaims(claims *Claims) error {
+	if claims.Issuer != v.Issuer {
+		return fmt.Errorf("%w: %q", ErrTokenIssuer, claims.Issuer)
+	}
+	if v.Audience != "" && !slices.Contains(claims.Audience, v.Audience) {
+		return fmt.Errorf("%w: %q", ErrTokenAudience, claims.Audience)
+	}
+	return nil
+}
+
+// tokenError maps the errors of the jwt package to ours.
+func tokenError(err error) error {
+	var reason error
+	switch {
+	case errors.Is(err, ErrTokenAlgorithm):
+		// Returned by keyFunc and already wrapped in ours.
+		return err
+	case errors.Is(err, jwt.ErrTokenMalformed), errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		reason = ErrTokenMalformed
+	case errors.Is(err, jwt.ErrTokenUnverifiable), errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		reason = ErrTokenSignature
+	case errors.Is(err, jwt.ErrTokenExpired):
+		reason = ErrTokenExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		reason = ErrTokenNotValidYet
+	default:
+		return err
+	}
+	return fmt.Errorf("%w: %w", reason, err)
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func signClaims(t *testing.T, claims Claims, secret string) string {
+	t.Helper()
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokenString
+}
+
+func TestValidatorErrors(t *testing.T) {
+	const secret = "secret"
+	km := NewHMACKeyManager(secret)
+	if err := km.SetValidator(Validator{Audience: "chirpy-api", Leeway: 30 * time.Second}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	valid := func() Claims {
+		return Claims{RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    DefaultIssuer,
+			Audience:  jwt.ClaimStrings{"chirpy-api"},
+			Subject:   uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		}}
+	}
+
+	tests := []struct {
+		name     string
+		token    func() string
+		expected error
+	}{
+		{
+			name: "valid",
+			token: func() string {
+				return signClaims(t, valid(), secret)
+			},
+		},
+		{
+			name: "expired within leeway",
+			token: func() string {
+				c := valid()
+				c.ExpiresAt = jwt.NewNumericDate(now.Add(-10 * time.Second))
+				return signClaims(t, c, secret)
+			},
+		},
+		{
+			name: "expired",
+			token: func() string {
+				c := valid()
+				c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+				return signClaims(t, c, secret)
+			},
+			expected: ErrTokenExpired,
+		},
+		{
+			name: "no expiry",
+			token: func() string {
+				c := valid()
+				c.ExpiresAt = nil
+				return signClaims(t, c, secret)
+			},
+			expected: ErrTokenMalformed,
+		},
+		{
+			name: "issued in the future",
+			token: func() string {
+				c := valid()
+				c.IssuedAt = jwt.NewNumericDate(now.Add(time.Minute))
+				return signClaims(t, c, secret)
+			},
+			expected: ErrTokenNotValidYet,
+		},
+		{
+			name: "bad signature",
+			token: func() string {
+				return signClaims(t, valid(), "other secret")
+			},
+			expected: ErrTokenSignature,
+		},
+		{
+			name: "wrong issuer",
+			token: func() string {
+				c := valid()
+				c.Issuer = "someone-else"
+				return signClaims(t, c, secret)
+			},
+			expected: ErrTokenIssuer,
+		},
+		{
+			name: "missing issuer",
+			token: func() string {
+				c := valid()
+				c.Issuer = ""
+				return signClaims(t, c, secret)
+			},
+			expected: ErrTokenIssuer,
+		},
+		{
+			name: "wrong audience",
+			token: func() string {
+				c := valid()
+				c.Audience = jwt.ClaimStrings{"other-api"}
+				return signClaims(t, c, secret)
+			},
+			expected: ErrTokenAudience,
+		},
+		{
+			name: "missing audience",
+			token: func() string {
+				c := valid()
+				c.Audience = nil
+				return signClaims(t, c, secret)
+			},
+			expected: ErrTokenAudience,
+		},
+		{
+			name: "unsigned",
+			token: func() string {
+				tokenString, _ := jwt.NewWithClaims(jwt.SigningMethodNone, valid()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+				return tokenString
+			},
+			expected: ErrTokenAlgorithm,
+		},
+		{
+			name: "garbage",
+			token: func() string {
+				return "not.a.token"
+			},
+			expected: ErrTokenMalformed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := km.ParseJWT(tt.token())
+			if tt.expected == nil {
+				if err != nil {
+					t.Errorf("expected token to be valid, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestValidatorIssuesMatchingTokens(t *testing.T) {
+	km := NewHMACKeyManager("secret")
+	if err := km.SetValidator(Validator{Audience: "chirpy-api"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, err := km.MakeJWT(TokenSubject{UserID: uuid.New()}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := km.ParseJWT(tokenString)
+	if err != nil {
+		t.Fatalf("ParseJWT() error = %v", err)
+	}
+	if claims.Issuer != DefaultIssuer || len(claims.Audience) != 1 || claims.Audience[0] != "chirpy-api" {
+		t.Errorf("unexpected iss %q and aud %v", claims.Issuer, claims.Audience)
+	}
+
+	// A token for another audience, signed with the same secret.
+	other := NewHMACKeyManager("secret")
+	if err := other.SetValidator(Validator{Audience: "other-api"}); err != nil {
+		t.Fatal(err)
+	}
+	tokenString, _ = other.MakeJWT(TokenSubject{UserID: uuid.New()}, time.Minute)
+	if _, err := km.ParseJWT(tokenString); !errors.Is(err, ErrTokenAudience) {
+		t.Errorf("expected ErrTokenAudience, got %v", err)
+	}
+}
+
+func TestValidatorAlgorithms(t *testing.T) {
+	dir, _ := newKeysDir(t)
+	km, err := LoadKeyManager(dir, "ed-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKM, err := LoadKeyManager(dir, "rsa-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenString, err := rsaKM.MakeJWT(TokenSubject{UserID: uuid.New()}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := km.ParseJWT(tokenString); err != nil {
+		t.Fatalf("expected RS256 token to verify without an allowlist, got %v", err)
+	}
+	if err := km.SetValidator(Validator{Algorithms: []string{"EdDSA"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := km.ParseJWT(tokenString); !errors.Is(err, ErrTokenAlgorithm) {
+		t.Errorf("expected ErrTokenAlgorithm once RS256 is no longer allowed, got %v", err)
+	}
+
+	if err := km.SetValidator(Validator{Algorithms: []string{"RS256"}}); err == nil {
+		t.Error("expected an error when the active key's algorithm isn't allowed")
+	}
+}
@@ -0,0 +1,84 @@
+package chirptext
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxTagLength caps how long a single hashtag can be, in characters.
+const MaxTagLength = 64
+
+// A hashtag starts with '#' at the beginning of the body or after a character
+// that can't be part of a word, so "a#b" and URL fragments aren't tags.
+var hashtagRegexp = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&/])#([\p{L}\p{N}_]+)`)
+
+// Hashtags returns the normalized (lowercase, deduplicated) tags found in a
+// chirp body, in the order they first appear. Purely numeric tags like "#1"
+// are ignored.
+func Hashtags(body string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+
+	for _, match := range hashtagRegexp.FindAllStringSubmatch(body, -1) {
+		tag := NormalizeTag(match[1])
+		if tag == "" || isNumeric(tag) || utf8.RuneCountInString(tag) > MaxTagLength || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// A mention is '@' followed by either a handle or a full email address, at
+// the beginning of the body or after a character that can't be part of one.
+var mentionRegexp = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.+\-@])@([\p{L}\p{N}_.+\-]+(?:@[\p{L}\p{N}\-]+(?:\.[\p{L}\p{N}\-]+)+)?)`)
+
+// Mention is a reference to a user in a chirp body. Users are mentioned
+// either by email ("@ana@example.com") or by handle ("@ana"), the part of
+// their email before the '@'. Exactly one of Email and Handle is set.
+type Mention struct {
+	Email  string
+	Handle string
+}
+
+// Mentions returns the normalized (lowercase, deduplicated) mentions found in
+// a chirp body, in the order they first appear.
+func Mentions(body string) []Mention {
+	mentions := []Mention{}
+	seen := map[Mention]bool{}
+
+	for _, match := range mentionRegexp.FindAllStringSubmatch(body, -1) {
+		// A sentence can end right after a mention, "thanks @ana."
+		ref := strings.ToLower(strings.TrimRight(match[1], "."))
+		if ref == "" {
+			continue
+		}
+
+		mention := Mention{Handle: ref}
+		if strings.Contains(ref, "@") {
+			mention = Mention{Email: ref}
+		}
+		if seen[mention] {
+			continue
+		}
+		seen[mention] = true
+		mentions = append(mentions, mention)
+	}
+	return mentions
+}
+
+// NormalizeTag turns user input such as "#Golang" into the stored form.
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+func isNumeric(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
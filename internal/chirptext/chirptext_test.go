@@ -0,0 +1,97 @@
+package chirptext
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHashtags(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected []string
+	}{
+		{
+			name:     "no tags",
+			body:     "just a regular chirp",
+			expected: []string{},
+		},
+		{
+			name:     "tags are lowercased and deduplicated",
+			body:     "#Go is great, #go is fun, #Chirpy",
+			expected: []string{"go", "chirpy"},
+		},
+		{
+			name:     "punctuation ends a tag",
+			body:     "loving #golang! and #sql.",
+			expected: []string{"golang", "sql"},
+		},
+		{
+			name:     "unicode tags",
+			body:     "#café con #niño",
+			expected: []string{"café", "niño"},
+		},
+		{
+			name:     "tags inside words and urls are ignored",
+			body:     "mail a#b or visit http://example.com/#section",
+			expected: []string{},
+		},
+		{
+			name:     "numeric tags are ignored",
+			body:     "we are #1 at #go_2024",
+			expected: []string{"go_2024"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags := Hashtags(tt.body)
+			if !reflect.DeepEqual(tags, tt.expected) {
+				t.Errorf("expected tags: %v, got tags: %v", tt.expected, tags)
+			}
+		})
+	}
+}
+
+func TestMentions(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected []Mention
+	}{
+		{
+			name:     "no mentions",
+			body:     "just a regular chirp",
+			expected: []Mention{},
+		},
+		{
+			name: "handles and emails",
+			body: "hey @Ana and @bob@Example.com!",
+			expected: []Mention{
+				{Handle: "ana"},
+				{Email: "bob@example.com"},
+			},
+		},
+		{
+			name: "trailing dot and duplicates",
+			body: "thanks @ana. really, @ana",
+			expected: []Mention{
+				{Handle: "ana"},
+			},
+		},
+		{
+			name:     "plain email addresses aren't mentions",
+			body:     "write to ana@example.com",
+			expected: []Mention{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mentions := Mentions(tt.body)
+			if !reflect.DeepEqual(mentions, tt.expected) {
+				t.Errorf("expected mentions: %v, got mentions: %v", tt.expected, mentions)
+			}
+		})
+	}
+}
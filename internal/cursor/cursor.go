@@ -0,0 +1,62 @@
+package cursor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidCursor is returned when a cursor is malformed or its signature
+// doesn't match.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks the last row of a page in a (created_at, id) keyset.
+type Cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+}
+
+// Encode serializes the cursor and signs it so clients can't forge positions.
+func Encode(c Cursor, secret string) string {
+	payload, _ := json.Marshal(c)
+	encPayload := base64.RawURLEncoding.EncodeToString(payload)
+	encSig := base64.RawURLEncoding.EncodeToString(sign(encPayload, secret))
+
+	return encPayload + "." + encSig
+}
+
+// Decode verifies the signature of an opaque cursor and returns its position.
+func Decode(token, secret string) (Cursor, error) {
+	encPayload, encSig, found := strings.Cut(token, ".")
+	if !found {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil || !hmac.Equal(sig, sign(encPayload, secret)) {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	c := Cursor{}
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+func sign(payload, secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
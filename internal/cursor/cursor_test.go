@@ -0,0 +1,71 @@
+package cursor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestEncodeAndDecode(t *testing.T) {
+	secret := "secret"
+	c := Cursor{
+		CreatedAt: time.Date(2024, 10, 1, 12, 30, 0, 123456000, time.UTC),
+		ID:        uuid.New(),
+	}
+
+	token := Encode(c, secret)
+
+	decoded, err := Decode(token, secret)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !decoded.CreatedAt.Equal(c.CreatedAt) || decoded.ID != c.ID {
+		t.Errorf("cursor was not decoded correctly: %v != %v", decoded, c)
+	}
+
+	_, err = Decode(token, "randomSecret")
+	if err == nil {
+		t.Error("Cursor with invalid secret is being decoded")
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	secret := "secret"
+	valid := Encode(Cursor{CreatedAt: time.Now(), ID: uuid.New()}, secret)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{
+			name:  "empty cursor",
+			token: "",
+		},
+		{
+			name:  "missing signature",
+			token: "eyJ0IjoiMjAyNCJ9",
+		},
+		{
+			name:  "tampered payload",
+			token: "x" + valid,
+		},
+		{
+			name:  "tampered signature",
+			token: valid + "x",
+		},
+		{
+			name:  "not base64",
+			token: "!!!.???",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(tt.token, secret)
+			if err != ErrInvalidCursor {
+				t.Errorf("expected ErrInvalidCursor, got %v", err)
+			}
+		})
+	}
+}
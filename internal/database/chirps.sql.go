@@ -7,29 +7,119 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const addChirp = `-- name: AddChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
-VALUES (
-    gen_random_uuid(),
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, reply_to, thread_id, quote_of)
+SELECT
+    new_chirp.id,
     NOW(),
     NOW(),
-    $1,
-    $2
-)
-RETURNING id, created_at, updated_at, body, user_id
+    $1::text,
+    $2::uuid,
+    $3::uuid,
+    COALESCE($4::uuid, new_chirp.id),
+    $5::uuid
+FROM (SELECT gen_random_uuid() AS id) AS new_chirp
+RETURNING id, created_at, updated_at, body, user_id, reply_to, thread_id, deleted_at, rechirp_of, quote_of, hidden_at
 `
 
 type AddChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body     string
+	UserID   uuid.UUID
+	ReplyTo  uuid.NullUUID
+	ThreadID uuid.NullUUID
+	QuoteOf  uuid.NullUUID
 }
 
 func (q *Queries) AddChirp(ctx context.Context, arg AddChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, addChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, addChirp,
+		arg.Body,
+		arg.UserID,
+		arg.ReplyTo,
+		arg.ThreadID,
+		arg.QuoteOf,
+	)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.ReplyTo,
+		&i.ThreadID,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.QuoteOf,
+		&i.HiddenAt,
+	)
+	return i, err
+}
+
+const addRechirp = `-- name: AddRechirp :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, thread_id, rechirp_of)
+SELECT
+    new_chirp.id,
+    NOW(),
+    NOW(),
+    '',
+    $1::uuid,
+    new_chirp.id,
+    $2::uuid
+FROM (SELECT gen_random_uuid() AS id) AS new_chirp
+ON CONFLICT (user_id, rechirp_of) WHERE rechirp_of IS NOT NULL DO NOTHING
+RETURNING id, created_at, updated_at, body, user_id, reply_to, thread_id, deleted_at, rechirp_of, quote_of, hidden_at
+`
+
+type AddRechirpParams struct {
+	UserID    uuid.UUID
+	RechirpOf uuid.UUID
+}
+
+func (q *Queries) AddRechirp(ctx context.Context, arg AddRechirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, addRechirp, arg.UserID, arg.RechirpOf)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.ReplyTo,
+		&i.ThreadID,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.QuoteOf,
+		&i.HiddenAt,
+	)
+	return i, err
+}
+
+const countChirpReferences = `-- name: CountChirpReferences :one
+SELECT COUNT(*) FROM chirps
+WHERE reply_to = $1::uuid OR quote_of = $1::uuid
+`
+
+func (q *Queries) CountChirpReferences(ctx context.Context, chirpID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countChirpReferences, chirpID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const getChirp = `-- name: GetChirp :one
+SELECT id, created_at, updated_at, body, user_id, reply_to, thread_id, deleted_at, rechirp_of, quote_of, hidden_at FROM chirps
+WHERE id = $1
+`
+
+func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirp, id)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -37,17 +127,48 @@ func (q *Queries) AddChirp(ctx context.Context, arg AddChirpParams) (Chirp, erro
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.ReplyTo,
+		&i.ThreadID,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.QuoteOf,
+		&i.HiddenAt,
 	)
 	return i, err
 }
 
-const getAllChirpsAscOrder = `-- name: GetAllChirpsAscOrder :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-ORDER BY created_at ASC
+const getChirpForUpdate = `-- name: GetChirpForUpdate :one
+SELECT id, created_at, updated_at, body, user_id, reply_to, thread_id, deleted_at, rechirp_of, quote_of, hidden_at FROM chirps
+WHERE id = $1
+FOR UPDATE
 `
 
-func (q *Queries) GetAllChirpsAscOrder(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirpsAscOrder)
+func (q *Queries) GetChirpForUpdate(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpForUpdate, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.ReplyTo,
+		&i.ThreadID,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.QuoteOf,
+		&i.HiddenAt,
+	)
+	return i, err
+}
+
+const getChirpsByIDs = `-- name: GetChirpsByIDs :many
+SELECT id, created_at, updated_at, body, user_id, reply_to, thread_id, deleted_at, rechirp_of, quote_of, hidden_at FROM chirps
+WHERE id = ANY($1::uuid[])
+`
+
+func (q *Queries) GetChirpsByIDs(ctx context.Context, ids []uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByIDs, pq.Array(ids))
 	if err != nil {
 		return nil, err
 	}
@@ -61,6 +182,12 @@ func (q *Queries) GetAllChirpsAscOrder(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.ReplyTo,
+			&i.ThreadID,
+			&i.DeletedAt,
+			&i.RechirpOf,
+			&i.QuoteOf,
+			&i.HiddenAt,
 		); err != nil {
 			return nil, err
 		}
@@ -75,13 +202,50 @@ func (q *Queries) GetAllChirpsAscOrder(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getAllChirpsDescOrder = `-- name: GetAllChirpsDescOrder :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-ORDER BY created_at DESC
+const getRechirp = `-- name: GetRechirp :one
+SELECT id, created_at, updated_at, body, user_id, reply_to, thread_id, deleted_at, rechirp_of, quote_of, hidden_at FROM chirps
+WHERE user_id = $1 AND rechirp_of = $2
 `
 
-func (q *Queries) GetAllChirpsDescOrder(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirpsDescOrder)
+type GetRechirpParams struct {
+	UserID    uuid.UUID
+	RechirpOf uuid.NullUUID
+}
+
+func (q *Queries) GetRechirp(ctx context.Context, arg GetRechirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getRechirp, arg.UserID, arg.RechirpOf)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.ReplyTo,
+		&i.ThreadID,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.QuoteOf,
+		&i.HiddenAt,
+	)
+	return i, err
+}
+
+const getThread = `-- name: GetThread :many
+SELECT id, created_at, updated_at, body, user_id, reply_to, thread_id, deleted_at, rechirp_of, quote_of, hidden_at FROM chirps
+WHERE thread_id = $1
+AND (hidden_at IS NULL OR user_id = $2::uuid OR $3::bool)
+ORDER BY created_at ASC, id ASC
+`
+
+type GetThreadParams struct {
+	ThreadID      uuid.UUID
+	ViewerID      uuid.NullUUID
+	IncludeHidden bool
+}
+
+func (q *Queries) GetThread(ctx context.Context, arg GetThreadParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getThread, arg.ThreadID, arg.ViewerID, arg.IncludeHidden)
 	if err != nil {
 		return nil, err
 	}
@@ -95,6 +259,12 @@ func (q *Queries) GetAllChirpsDescOrder(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.ReplyTo,
+			&i.ThreadID,
+			&i.DeletedAt,
+			&i.RechirpOf,
+			&i.QuoteOf,
+			&i.HiddenAt,
 		); err != nil {
 			return nil, err
 		}
@@ -109,31 +279,129 @@ func (q *Queries) GetAllChirpsDescOrder(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getChirp = `-- name: GetChirp :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+const hideChirp = `-- name: HideChirp :execrows
+UPDATE chirps
+SET hidden_at = NOW()
 WHERE id = $1
+AND deleted_at IS NULL
+AND hidden_at IS NULL
 `
 
-func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, getChirp, id)
-	var i Chirp
-	err := row.Scan(
-		&i.ID,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-		&i.Body,
-		&i.UserID,
+func (q *Queries) HideChirp(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, hideChirp, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const listChirpsAsc = `-- name: ListChirpsAsc :many
+SELECT id, created_at, updated_at, body, user_id, reply_to, thread_id, deleted_at, rechirp_of, quote_of, hidden_at FROM chirps
+WHERE ($1::uuid[] IS NULL OR user_id = ANY($1::uuid[]))
+AND ($2::timestamp IS NULL OR created_at >= $2::timestamp)
+AND ($3::timestamp IS NULL OR created_at < $3::timestamp)
+AND deleted_at IS NULL
+AND (hidden_at IS NULL OR user_id = $4::uuid OR $5::bool)
+AND (
+    $6::timestamp IS NULL
+    OR (created_at, id) > ($6::timestamp, $7::uuid)
+)
+ORDER BY created_at ASC, id ASC
+LIMIT $8
+`
+
+type ListChirpsAscParams struct {
+	AuthorIds       []uuid.UUID
+	Since           sql.NullTime
+	Until           sql.NullTime
+	ViewerID        uuid.NullUUID
+	IncludeHidden   bool
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) ListChirpsAsc(ctx context.Context, arg ListChirpsAscParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsAsc,
+		pq.Array(arg.AuthorIds),
+		arg.Since,
+		arg.Until,
+		arg.ViewerID,
+		arg.IncludeHidden,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
 	)
-	return i, err
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.ReplyTo,
+			&i.ThreadID,
+			&i.DeletedAt,
+			&i.RechirpOf,
+			&i.QuoteOf,
+			&i.HiddenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const getChirpsFromUser = `-- name: GetChirpsFromUser :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-WHERE user_id = $1
+const listChirpsDesc = `-- name: ListChirpsDesc :many
+SELECT id, created_at, updated_at, body, user_id, reply_to, thread_id, deleted_at, rechirp_of, quote_of, hidden_at FROM chirps
+WHERE ($1::uuid[] IS NULL OR user_id = ANY($1::uuid[]))
+AND ($2::timestamp IS NULL OR created_at >= $2::timestamp)
+AND ($3::timestamp IS NULL OR created_at < $3::timestamp)
+AND deleted_at IS NULL
+AND (hidden_at IS NULL OR user_id = $4::uuid OR $5::bool)
+AND (
+    $6::timestamp IS NULL
+    OR (created_at, id) < ($6::timestamp, $7::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT $8
 `
 
-func (q *Queries) GetChirpsFromUser(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsFromUser, userID)
+type ListChirpsDescParams struct {
+	AuthorIds       []uuid.UUID
+	Since           sql.NullTime
+	Until           sql.NullTime
+	ViewerID        uuid.NullUUID
+	IncludeHidden   bool
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) ListChirpsDesc(ctx context.Context, arg ListChirpsDescParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsDesc,
+		pq.Array(arg.AuthorIds),
+		arg.Since,
+		arg.Until,
+		arg.ViewerID,
+		arg.IncludeHidden,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -147,6 +415,73 @@ func (q *Queries) GetChirpsFromUser(ctx context.Context, userID uuid.UUID) ([]Ch
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.ReplyTo,
+			&i.ThreadID,
+			&i.DeletedAt,
+			&i.RechirpOf,
+			&i.QuoteOf,
+			&i.HiddenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTimeline = `-- name: ListTimeline :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.reply_to, chirps.thread_id, chirps.deleted_at, chirps.rechirp_of, chirps.quote_of, chirps.hidden_at FROM chirps
+JOIN follows ON follows.followee_id = chirps.user_id
+WHERE follows.follower_id = $1
+AND chirps.deleted_at IS NULL
+AND chirps.hidden_at IS NULL
+AND (
+    $2::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) < ($2::timestamp, $3::uuid)
+)
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT $4
+`
+
+type ListTimelineParams struct {
+	FollowerID      uuid.UUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) ListTimeline(ctx context.Context, arg ListTimelineParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listTimeline,
+		arg.FollowerID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.ReplyTo,
+			&i.ThreadID,
+			&i.DeletedAt,
+			&i.RechirpOf,
+			&i.QuoteOf,
+			&i.HiddenAt,
 		); err != nil {
 			return nil, err
 		}
@@ -170,3 +505,178 @@ func (q *Queries) RemoveChirp(ctx context.Context, id uuid.UUID) error {
 	_, err := q.db.ExecContext(ctx, removeChirp, id)
 	return err
 }
+
+const removeRechirp = `-- name: RemoveRechirp :execrows
+DELETE FROM chirps
+WHERE user_id = $1 AND rechirp_of = $2
+`
+
+type RemoveRechirpParams struct {
+	UserID    uuid.UUID
+	RechirpOf uuid.NullUUID
+}
+
+func (q *Queries) RemoveRechirp(ctx context.Context, arg RemoveRechirpParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, removeRechirp, arg.UserID, arg.RechirpOf)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const removeRechirpsOf = `-- name: RemoveRechirpsOf :exec
+DELETE FROM chirps
+WHERE rechirp_of = $1
+`
+
+func (q *Queries) RemoveRechirpsOf(ctx context.Context, rechirpOf uuid.NullUUID) error {
+	_, err := q.db.ExecContext(ctx, removeRechirpsOf, rechirpOf)
+	return err
+}
+
+const restoreChirp = `-- name: RestoreChirp :execrows
+UPDATE chirps
+SET hidden_at = NULL
+WHERE id = $1
+AND hidden_at IS NOT NULL
+`
+
+func (q *Queries) RestoreChirp(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, restoreChirp, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const searchChirps = `-- name: SearchChirps :many
+SELECT
+    chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.reply_to, chirps.thread_id, chirps.deleted_at, chirps.rechirp_of, chirps.quote_of, chirps.hidden_at,
+    ts_rank_cd(to_tsvector('english', body), to_tsquery('english', $1::text))::real AS rank,
+    ts_headline(
+        'english',
+        body,
+        to_tsquery('english', $1::text),
+        'MaxFragments=2, MinWords=5, MaxWords=20, StartSel=' || chr(57344) || ', StopSel=' || chr(57345)
+    )::text AS snippet
+FROM chirps
+WHERE to_tsvector('english', body) @@ to_tsquery('english', $1::text)
+AND deleted_at IS NULL
+AND hidden_at IS NULL
+AND ($2::uuid[] IS NULL OR user_id = ANY($2::uuid[]))
+AND ($3::timestamp IS NULL OR created_at >= $3::timestamp)
+AND ($4::timestamp IS NULL OR created_at < $4::timestamp)
+ORDER BY rank DESC, created_at DESC, id DESC
+LIMIT $5 OFFSET $6
+`
+
+type SearchChirpsParams struct {
+	Query      string
+	AuthorIds  []uuid.UUID
+	Since      sql.NullTime
+	Until      sql.NullTime
+	PageLimit  int32
+	PageOffset int32
+}
+
+type SearchChirpsRow struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Body      string
+	UserID    uuid.UUID
+	ReplyTo   uuid.NullUUID
+	ThreadID  uuid.UUID
+	DeletedAt sql.NullTime
+	RechirpOf uuid.NullUUID
+	QuoteOf   uuid.NullUUID
+	HiddenAt  sql.NullTime
+	Rank      float32
+	Snippet   string
+}
+
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps,
+		arg.Query,
+		pq.Array(arg.AuthorIds),
+		arg.Since,
+		arg.Until,
+		arg.PageLimit,
+		arg.PageOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsRow
+	for rows.Next() {
+		var i SearchChirpsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.ReplyTo,
+			&i.ThreadID,
+			&i.DeletedAt,
+			&i.RechirpOf,
+			&i.QuoteOf,
+			&i.HiddenAt,
+			&i.Rank,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const tombstoneChirp = `-- name: TombstoneChirp :exec
+UPDATE chirps
+SET body = '', deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) TombstoneChirp(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, tombstoneChirp, id)
+	return err
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, body, user_id, reply_to, thread_id, deleted_at, rechirp_of, quote_of, hidden_at
+`
+
+type UpdateChirpBodyParams struct {
+	ID   uuid.UUID
+	Body string
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.ID, arg.Body)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.ReplyTo,
+		&i.ThreadID,
+		&i.DeletedAt,
+		&i.RechirpOf,
+		&i.QuoteOf,
+		&i.HiddenAt,
+	)
+	return i, err
+}
@@ -9,13 +9,14 @@ import (
 	"context"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const changeUserCredentials = `-- name: ChangeUserCredentials :one
 UPDATE users
-SET email = $2, hashed_password = $3
+SET email = $2, hashed_password = $3, email_verified = email_verified AND email = $2
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, chirpy_red, suspended_at, role, token_version, email_verified
 `
 
 type ChangeUserCredentialsParams struct {
@@ -24,6 +25,7 @@ type ChangeUserCredentialsParams struct {
 	HashedPassword string
 }
 
+// A new address has to be verified again.
 func (q *Queries) ChangeUserCredentials(ctx context.Context, arg ChangeUserCredentialsParams) (User, error) {
 	row := q.db.QueryRowContext(ctx, changeUserCredentials, arg.ID, arg.Email, arg.HashedPassword)
 	var i User
@@ -34,6 +36,43 @@ func (q *Queries) ChangeUserCredentials(ctx context.Context, arg ChangeUserCrede
 		&i.Email,
 		&i.HashedPassword,
 		&i.ChirpyRed,
+		&i.SuspendedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.EmailVerified,
+	)
+	return i, err
+}
+
+const createExternalUser = `-- name: CreateExternalUser :one
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, email_verified)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    '',
+    true
+)
+RETURNING id, created_at, updated_at, email, hashed_password, chirpy_red, suspended_at, role, token_version, email_verified
+`
+
+// Users who sign in with an identity provider have no password, so they
+// can't log in with one until they reset it.
+func (q *Queries) CreateExternalUser(ctx context.Context, email string) (User, error) {
+	row := q.db.QueryRowContext(ctx, createExternalUser, email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.ChirpyRed,
+		&i.SuspendedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.EmailVerified,
 	)
 	return i, err
 }
@@ -47,7 +86,7 @@ VALUES (
     $1,
     $2
 )
-RETURNING id, created_at, updated_at, email, hashed_password, chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, chirpy_red, suspended_at, role, token_version, email_verified
 `
 
 type CreateUserParams struct {
@@ -65,6 +104,10 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.ChirpyRed,
+		&i.SuspendedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.EmailVerified,
 	)
 	return i, err
 }
@@ -80,8 +123,31 @@ func (q *Queries) DowngradeUser(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
+const getUser = `-- name: GetUser :one
+SELECT id, created_at, updated_at, email, hashed_password, chirpy_red, suspended_at, role, token_version, email_verified FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.ChirpyRed,
+		&i.SuspendedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.EmailVerified,
+	)
+	return i, err
+}
+
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, chirpy_red FROM users
+SELECT id, created_at, updated_at, email, hashed_password, chirpy_red, suspended_at, role, token_version, email_verified FROM users
 WHERE email = $1
 `
 
@@ -95,10 +161,129 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.HashedPassword,
 		&i.ChirpyRed,
+		&i.SuspendedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.EmailVerified,
+	)
+	return i, err
+}
+
+const getUserTokenVersion = `-- name: GetUserTokenVersion :one
+SELECT token_version FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserTokenVersion(ctx context.Context, id uuid.UUID) (int32, error) {
+	row := q.db.QueryRowContext(ctx, getUserTokenVersion, id)
+	var token_version int32
+	err := row.Scan(&token_version)
+	return token_version, err
+}
+
+const incrementTokenVersion = `-- name: IncrementTokenVersion :exec
+UPDATE users
+SET token_version = token_version + 1
+WHERE id = $1
+`
+
+func (q *Queries) IncrementTokenVersion(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, incrementTokenVersion, id)
+	return err
+}
+
+const promoteAdmins = `-- name: PromoteAdmins :execrows
+UPDATE users
+SET role = 'admin', updated_at = NOW()
+WHERE email = ANY($1::text[])
+AND email_verified
+AND role <> 'admin'
+`
+
+// Only verified addresses are promoted, or anyone could sign up with an
+// admin's address before its owner does.
+func (q *Queries) PromoteAdmins(ctx context.Context, emails []string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, promoteAdmins, pq.Array(emails))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setUserRole = `-- name: SetUserRole :one
+UPDATE users
+SET role = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, chirpy_red, suspended_at, role, token_version, email_verified
+`
+
+type SetUserRoleParams struct {
+	ID   uuid.UUID
+	Role string
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserRole, arg.ID, arg.Role)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.ChirpyRed,
+		&i.SuspendedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.EmailVerified,
 	)
 	return i, err
 }
 
+const suspendUser = `-- name: SuspendUser :execrows
+UPDATE users
+SET suspended_at = COALESCE(suspended_at, NOW())
+WHERE id = $1
+`
+
+func (q *Queries) SuspendUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, suspendUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const unsuspendUser = `-- name: UnsuspendUser :execrows
+UPDATE users
+SET suspended_at = NULL
+WHERE id = $1
+`
+
+func (q *Queries) UnsuspendUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, unsuspendUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type UpdateUserPasswordParams struct {
+	ID             uuid.UUID
+	HashedPassword string
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.ID, arg.HashedPassword)
+	return err
+}
+
 const upgradeUser = `-- name: UpgradeUser :exec
 UPDATE users
 SET chirpy_red = true
@@ -109,3 +294,22 @@ func (q *Queries) UpgradeUser(ctx context.Context, id uuid.UUID) error {
 	_, err := q.db.ExecContext(ctx, upgradeUser, id)
 	return err
 }
+
+const verifyUserEmail = `-- name: VerifyUserEmail :execrows
+UPDATE users
+SET email_verified = true, updated_at = NOW()
+WHERE id = $1 AND email = $2
+`
+
+type VerifyUserEmailParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, verifyUserEmail, arg.ID, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"errors"
+	netmail "net/mail"
+	"strings"
+)
+
+// maxAddressLength is the longest address SMTP can deliver to (RFC 5321).
+const maxAddressLength = 254
+
+var ErrInvalidAddress = errors.New("invalid email address")
+
+// NormalizeAddress checks that addr is a bare address like
+// "walt@breakingbad.com", without a display name or angle brackets, and
+// lowercases it so the same mailbox always maps to the same account. Local
+// parts are case sensitive in theory, but no provider treats them that way.
+func NormalizeAddress(addr string) (string, error) {
+	addr = strings.TrimSpace(addr)
+	if addr == "" || len(addr) > maxAddressLength {
+		return "", ErrInvalidAddress
+	}
+
+	parsed, err := netmail.ParseAddress(addr)
+	if err != nil || parsed.Name != "" || parsed.Address != addr {
+		return "", ErrInvalidAddress
+	}
+
+	// net/mail accepts dotless domains like "localhost", which we can't send
+	// to from the internet.
+	at := strings.LastIndex(addr, "@")
+	domain := addr[at+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "", ErrInvalidAddress
+	}
+
+	return strings.ToLower(addr), nil
+}
+
+// CanonicalAddress only trims and lowercases addr, the way NormalizeAddress
+// does, without checking it. It's for looking up accounts by an address
+// they already have, which may predate the checks.
+func CanonicalAddress(addr string) string {
+	return strings.ToLower(strings.TrimSpace(addr))
+}
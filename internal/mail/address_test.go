@@ -0,0 +1,63 @@
+package mail
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{input: "walt@breakingbad.com", expected: "walt@breakingbad.com"},
+		{input: "Walt@BreakingBad.COM", expected: "walt@breakingbad.com"},
+		{input: "  saul+clients@bettercall.co.uk ", expected: "saul+clients@bettercall.co.uk"},
+		{input: "", wantErr: true},
+		{input: "walt", wantErr: true},
+		{input: "walt@", wantErr: true},
+		{input: "@breakingbad.com", wantErr: true},
+		{input: "walt@localhost", wantErr: true},
+		{input: "walt@breakingbad.", wantErr: true},
+		{input: "walt@@breakingbad.com", wantErr: true},
+		{input: "Walter White <walt@breakingbad.com>", wantErr: true},
+		{input: "<walt@breakingbad.com>", wantErr: true},
+		{input: "walt@breaking bad.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := NormalizeAddress(tt.input)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidAddress) {
+					t.Errorf("expected ErrInvalidAddress, got %q, %v", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NormalizeAddress() error = %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCanonicalAddress(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "walt@breakingbad.com", expected: "walt@breakingbad.com"},
+		{input: " Walt@BreakingBad.COM\n", expected: "walt@breakingbad.com"},
+		{input: "Walt@LocalHost", expected: "walt@localhost"},
+	}
+
+	for _, tt := range tests {
+		if got := CanonicalAddress(tt.input); got != tt.expected {
+			t.Errorf("CanonicalAddress(%q) = %q, expected %q", tt.input, got, tt.expected)
+		}
+	}
+}
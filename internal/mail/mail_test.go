@@ -0,0 +1,66 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testMessage = Message{
+	To:      "walt@breakingbad.com",
+	Subject: "Reset your password",
+	Body:    "Your reset token is abc123",
+}
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := LogMailer{Logger: log.New(&buf, "", 0)}
+
+	if err := m.Send(context.Background(), testMessage); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	for _, want := range []string{testMessage.To, testMessage.Subject, testMessage.Body} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected log to contain %q, got %q", want, buf.String())
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := FileMailer{Dir: dir}
+
+	if err := m.Send(context.Background(), testMessage); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	evil := testMessage
+	evil.To = "../../etc/passwd"
+	if err := m.Send(context.Background(), evil); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 messages in %s, got %d", dir, len(entries))
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".eml") || strings.Contains(entry.Name(), "/") {
+			t.Errorf("unexpected file name %q", entry.Name())
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Subject: Reset your password\r\n\r\nYour reset token is abc123") {
+		t.Errorf("unexpected message contents %q", data)
+	}
+}
@@ -0,0 +1,278 @@
+package moderation
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+// Action is the policy applied when a rule matches.
+type Action string
+
+const (
+	// ActionMask replaces the matched word with asterisks.
+	ActionMask Action = "mask"
+	// ActionReject refuses the whole chirp.
+	ActionReject Action = "reject"
+	// ActionFlag keeps the chirp as is but marks it for review.
+	ActionFlag Action = "flag"
+)
+
+// Rule is a word and what to do when a chirp contains it.
+type Rule struct {
+	Word   string
+	Action Action
+}
+
+// DefaultRules is the word list Chirpy has always masked.
+var DefaultRules = []Rule{
+	{Word: "kerfuffle", Action: ActionMask},
+	{Word: "sharbert", Action: ActionMask},
+	{Word: "fornax", Action: ActionMask},
+}
+
+// Match is a rule that applied to a chirp body.
+type Match struct {
+	Word   string
+	Action Action
+}
+
+// Verdict is the outcome of running a body through a Filter.
+type Verdict struct {
+	// Body is the text to store, with masked words replaced.
+	Body     string
+	Rejected bool
+	Flagged  bool
+	Matches  []Match
+}
+
+// FlaggedWords lists the rule words that flagged the body for review.
+func (v Verdict) FlaggedWords() []string {
+	words := []string{}
+	for _, match := range v.Matches {
+		if match.Action == ActionFlag {
+			words = append(words, match.Word)
+		}
+	}
+	return words
+}
+
+// Filter decides whether, and how, a chirp body can be published.
+type Filter interface {
+	Apply(body string) Verdict
+}
+
+// Pipeline runs filters in order, each one seeing the body the previous one
+// produced. The chirp is rejected or flagged if any filter says so.
+type Pipeline []Filter
+
+func (p Pipeline) Apply(body string) Verdict {
+	verdict := Verdict{Body: body}
+	for _, filter := range p {
+		step := filter.Apply(verdict.Body)
+		verdict.Body = step.Body
+		verdict.Rejected = verdict.Rejected || step.Rejected
+		verdict.Flagged = verdict.Flagged || step.Flagged
+		verdict.Matches = append(verdict.Matches, step.Matches...)
+	}
+	return verdict
+}
+
+// Reloadable is a Filter whose rules can be replaced while chirps are
+// being checked against it.
+type Reloadable struct {
+	current atomic.Pointer[Pipeline]
+}
+
+// NewReloadable starts out filtering with f.
+func NewReloadable(f Filter) *Reloadable {
+	r := &Reloadable{}
+	r.Swap(f)
+	return r
+}
+
+// Swap makes later calls to Apply use f.
+func (r *Reloadable) Swap(f Filter) {
+	r.current.Store(&Pipeline{f})
+}
+
+func (r *Reloadable) Apply(body string) Verdict {
+	return r.current.Load().Apply(body)
+}
+
+const mask = "****"
+
+// WordFilter matches whole words against a word list. Words are compared
+// after Normalize, so "KERFUFFLE!", "k3rfuffl3" and "$harbert" all match.
+type WordFilter struct {
+	rules map[string]Rule
+}
+
+// NewWordFilter builds a filter from rules. If a word appears more than once
+// the last rule wins, so later sources can override earlier ones.
+func NewWordFilter(rules []Rule) *WordFilter {
+	f := &WordFilter{rules: make(map[string]Rule, len(rules))}
+	for _, rule := range rules {
+		f.rules[Normalize(rule.Word)] = rule
+	}
+	return f
+}
+
+func (f *WordFilter) Apply(body string) Verdict {
+	verdict := Verdict{}
+	var out strings.Builder
+	last := 0
+
+	for _, tok := range tokenize(body) {
+		rule, start, end, ok := f.match(body, tok)
+		if !ok {
+			continue
+		}
+
+		verdict.Matches = append(verdict.Matches, Match{Word: rule.Word, Action: rule.Action})
+		switch rule.Action {
+		case ActionReject:
+			verdict.Rejected = true
+		case ActionFlag:
+			verdict.Flagged = true
+		default:
+			out.WriteString(body[last:start])
+			out.WriteString(mask)
+			last = end
+		}
+	}
+
+	out.WriteString(body[last:])
+	verdict.Body = out.String()
+	return verdict
+}
+
+// match checks a token as written and, failing that, without the symbols
+// around it, since '!' or '$' may be punctuation or a disguised letter.
+// It returns the byte range to mask.
+func (f *WordFilter) match(body string, tok token) (Rule, int, int, bool) {
+	if rule, ok := f.rules[Normalize(body[tok.start:tok.end])]; ok {
+		return rule, tok.start, tok.end, true
+	}
+
+	trimmed := strings.TrimFunc(body[tok.start:tok.end], isSymbol)
+	if trimmed == "" {
+		return Rule{}, 0, 0, false
+	}
+	if rule, ok := f.rules[Normalize(trimmed)]; ok {
+		start := tok.start + strings.Index(body[tok.start:tok.end], trimmed)
+		return rule, start, start + len(trimmed), true
+	}
+	return Rule{}, 0, 0, false
+}
+
+var leet = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'@': 'a',
+	'$': 's',
+	'!': 'i',
+	'|': 'i',
+	'+': 't',
+}
+
+// Normalize lowercases a word and undoes common leetspeak substitutions.
+func Normalize(word string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(word) {
+		if plain, ok := leet[r]; ok {
+			r = plain
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type token struct {
+	start, end int
+}
+
+// tokenize splits a body into words: runs of letters, digits and the
+// symbols leetspeak uses as letters. Everything else, in any script,
+// separates words.
+func tokenize(body string) []token {
+	tokens := []token{}
+	start := -1
+	for i, r := range body {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || isSymbol(r)
+		if inWord && start == -1 {
+			start = i
+		}
+		if !inWord && start != -1 {
+			tokens = append(tokens, token{start: start, end: i})
+			start = -1
+		}
+	}
+	if start != -1 {
+		tokens = append(tokens, token{start: start, end: len(body)})
+	}
+	return tokens
+}
+
+func isSymbol(r rune) bool {
+	_, ok := leet[r]
+	return ok && !unicode.IsDigit(r)
+}
+
+// ParseRules reads a word list with one rule per line: a word optionally
+// followed by its action (mask if omitted). Blank lines and lines starting
+// with '#' are ignored.
+func ParseRules(r io.Reader) ([]Rule, error) {
+	rules := []Rule{}
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: expected a word and an optional action", lineNum)
+		}
+
+		rule := Rule{Word: fields[0], Action: ActionMask}
+		if len(fields) == 2 {
+			action, err := ParseAction(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			rule.Action = action
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// LoadRulesFile reads a word list from disk, see ParseRules.
+func LoadRulesFile(path string) ([]Rule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseRules(file)
+}
+
+// ParseAction validates an action name.
+func ParseAction(s string) (Action, error) {
+	switch action := Action(strings.ToLower(s)); action {
+	case ActionMask, ActionReject, ActionFlag:
+		return action, nil
+	default:
+		return "", fmt.Errorf("unknown moderation action %q", s)
+	}
+}
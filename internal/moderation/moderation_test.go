@@ -0,0 +1,154 @@
+package moderation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWordFilterMask(t *testing.T) {
+	filter := NewWordFilter(DefaultRules)
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "clean body",
+			body:     "I had something interesting for breakfast",
+			expected: "I had something interesting for breakfast",
+		},
+		{
+			name:     "case insensitive",
+			body:     "This is a Kerfuffle opinion I need to share with the world",
+			expected: "This is a **** opinion I need to share with the world",
+		},
+		{
+			name:     "punctuation is kept",
+			body:     "What a kerfuffle! Sharbert, fornax.",
+			expected: "What a ****! ****, ****.",
+		},
+		{
+			name:     "leetspeak",
+			body:     "k3rfuffl3 and $harbert and f0rn@x",
+			expected: "**** and **** and ****",
+		},
+		{
+			name:     "words containing a bad word are left alone",
+			body:     "kerfuffles are not fornaxes",
+			expected: "kerfuffles are not fornaxes",
+		},
+		{
+			name:     "unicode separators",
+			body:     "¡kerfuffle!—sharbert",
+			expected: "¡****!—****",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict := filter.Apply(tt.body)
+			if verdict.Body != tt.expected {
+				t.Errorf("expected body: %q, got body: %q", tt.expected, verdict.Body)
+			}
+			if verdict.Rejected || verdict.Flagged {
+				t.Errorf("mask rules shouldn't reject or flag, got %+v", verdict)
+			}
+		})
+	}
+}
+
+func TestWordFilterPolicies(t *testing.T) {
+	filter := NewWordFilter([]Rule{
+		{Word: "spam", Action: ActionReject},
+		{Word: "crypto", Action: ActionFlag},
+		{Word: "fornax", Action: ActionMask},
+	})
+
+	verdict := filter.Apply("buy crypto now, fornax!")
+	if verdict.Rejected {
+		t.Errorf("expected body not to be rejected")
+	}
+	if !verdict.Flagged {
+		t.Errorf("expected body to be flagged")
+	}
+	if verdict.Body != "buy crypto now, ****!" {
+		t.Errorf("flagged words shouldn't be masked, got body: %q", verdict.Body)
+	}
+	if words := verdict.FlaggedWords(); !reflect.DeepEqual(words, []string{"crypto"}) {
+		t.Errorf("expected flagged words: [crypto], got: %v", words)
+	}
+
+	verdict = filter.Apply("no SPAM please")
+	if !verdict.Rejected {
+		t.Errorf("expected body to be rejected")
+	}
+}
+
+func TestWordFilterLastRuleWins(t *testing.T) {
+	filter := NewWordFilter([]Rule{
+		{Word: "fornax", Action: ActionMask},
+		{Word: "FORNAX", Action: ActionReject},
+	})
+	if verdict := filter.Apply("fornax"); !verdict.Rejected {
+		t.Errorf("expected the later rule to override the earlier one, got %+v", verdict)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	pipeline := Pipeline{
+		NewWordFilter([]Rule{{Word: "fornax", Action: ActionMask}}),
+		NewWordFilter([]Rule{{Word: "crypto", Action: ActionFlag}}),
+	}
+	verdict := pipeline.Apply("fornax crypto")
+	if verdict.Body != "**** crypto" {
+		t.Errorf("expected body: %q, got body: %q", "**** crypto", verdict.Body)
+	}
+	if !verdict.Flagged || verdict.Rejected {
+		t.Errorf("expected a flagged, not rejected, verdict, got %+v", verdict)
+	}
+	if len(verdict.Matches) != 2 {
+		t.Errorf("expected 2 matches, got %d", len(verdict.Matches))
+	}
+}
+
+func TestReloadable(t *testing.T) {
+	filter := NewReloadable(NewWordFilter([]Rule{{Word: "fornax", Action: ActionMask}}))
+	if verdict := filter.Apply("fornax crypto"); verdict.Body != "**** crypto" {
+		t.Errorf("expected body: %q, got body: %q", "**** crypto", verdict.Body)
+	}
+
+	filter.Swap(NewWordFilter([]Rule{{Word: "crypto", Action: ActionReject}}))
+	verdict := filter.Apply("fornax crypto")
+	if verdict.Body != "fornax crypto" || !verdict.Rejected {
+		t.Errorf("expected the new rules to apply, got %+v", verdict)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	input := `
+# comment
+kerfuffle
+spam reject
+crypto FLAG
+`
+	rules, err := ParseRules(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Rule{
+		{Word: "kerfuffle", Action: ActionMask},
+		{Word: "spam", Action: ActionReject},
+		{Word: "crypto", Action: ActionFlag},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("expected rules: %v, got rules: %v", expected, rules)
+	}
+
+	if _, err := ParseRules(strings.NewReader("spam delete")); err == nil {
+		t.Errorf("expected an error for an unknown action")
+	}
+	if _, err := ParseRules(strings.NewReader("too many fields")); err == nil {
+		t.Errorf("expected an error for a malformed line")
+	}
+}
@@ -0,0 +1,302 @@
+// Package oidc signs users in with an external OpenID Connect provider: it
+// discovers the provider's endpoints, runs the authorization code flow with
+// PKCE and verifies the ID tokens it gets back against the provider's keys.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LoronsoDev/chirpy/internal/auth"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// leeway is the clock skew tolerated between us and the provider.
+	leeway = time.Minute
+	// minKeyRefresh limits how often an unknown kid makes us fetch the
+	// provider's keys again, so junk tokens can't be used to hammer it.
+	minKeyRefresh = time.Minute
+	// maxResponseSize caps what we read from the provider.
+	maxResponseSize = 1 << 20
+)
+
+var (
+	// ErrInvalidIDToken is returned for ID tokens that fail verification.
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	// ErrNonce is returned for ID tokens issued for another login attempt.
+	ErrNonce = errors.New("ID token nonce doesn't match")
+)
+
+// signingMethods are the ID token algorithms we accept. Shared secret
+// algorithms aren't among them: the provider's keys are public.
+var signingMethods = []string{
+	jwt.SigningMethodRS256.Alg(),
+	jwt.SigningMethodES256.Alg(),
+	jwt.SigningMethodEdDSA.Alg(),
+}
+
+// Metadata is the part of the provider's discovery document we use.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Config is how we're registered with the provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes are requested on top of openid. Defaults to email.
+	Scopes []string
+}
+
+// Client is a relying party for a single provider.
+type Client struct {
+	config   Config
+	metadata Metadata
+	http     *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+// NewClient fetches the provider's discovery document and keys. httpClient
+// may be nil to use http.DefaultClient.
+func NewClient(ctx context.Context, config Config, httpClient *http.Client) (*Client, error) {
+	if config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("client id and redirect URL are required")
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"email"}
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	c := &Client{config: config, http: httpClient}
+
+	discoveryURL := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := c.getJSON(ctx, discoveryURL, &c.metadata); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	// The issuer in the document has to be exactly the one we were
+	// configured with, or a compromised document could vouch for tokens
+	// from someone else (OpenID Connect Discovery section 4.3).
+	if c.metadata.Issuer != config.Issuer {
+		return nil, fmt.Errorf("discovery: issuer %q doesn't match %q", c.metadata.Issuer, config.Issuer)
+	}
+	if c.metadata.AuthorizationEndpoint == "" || c.metadata.TokenEndpoint == "" || c.metadata.JWKSURI == "" {
+		return nil, errors.New("discovery: document is missing endpoints")
+	}
+
+	if err := c.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Metadata is the provider's discovery document.
+func (c *Client) Metadata() Metadata {
+	return c.metadata
+}
+
+// AuthCodeURL is where to send the user to sign in. state and nonce tie the
+// callback and ID token to this attempt; verifier is the PKCE code verifier
+// that has to be presented with the code.
+func (c *Client) AuthCodeURL(state, nonce, verifier string) string {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.config.ClientID},
+		"redirect_uri":          {c.config.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, c.config.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {auth.PKCEChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(c.metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return c.metadata.AuthorizationEndpoint + sep + params.Encode()
+}
+
+// Exchange redeems an authorization code at the token endpoint and returns
+// the raw ID token, which still has to go through VerifyIDToken.
+func (c *Client) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if c.config.ClientSecret == "" {
+		form.Set("client_id", c.config.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&body); err != nil {
+		return "", fmt.Errorf("token endpoint: %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint: %s: %s", body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token endpoint: no id_token in response")
+	}
+	return body.IDToken, nil
+}
+
+// IDToken is who the provider says signed in.
+type IDToken struct {
+	jwt.RegisteredClaims
+	Nonce           string `json:"nonce"`
+	AuthorizedParty string `json:"azp,omitempty"`
+	Email           string `json:"email"`
+	EmailVerified   bool   `json:"email_verified"`
+}
+
+// VerifyIDToken checks an ID token's signature against the provider's keys,
+// and that it was issued by the provider, to us, for the login attempt
+// with the given nonce (OpenID Connect Core section 3.1.3.7).
+func (c *Client) VerifyIDToken(ctx context.Context, rawToken, nonce string) (*IDToken, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithIssuer(c.metadata.Issuer),
+		jwt.WithAudience(c.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(leeway),
+	)
+	token, err := parser.ParseWithClaims(rawToken, &IDToken{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return c.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+	idToken, ok := token.Claims.(*IDToken)
+	if !ok || !token.Valid || idToken.Subject == "" {
+		return nil, ErrInvalidIDToken
+	}
+	if len(idToken.Audience) > 1 && idToken.AuthorizedParty != c.config.ClientID {
+		return nil, fmt.Errorf("%w: issued to %q", ErrInvalidIDToken, idToken.AuthorizedParty)
+	}
+	if nonce == "" || idToken.Nonce != nonce {
+		return nil, ErrNonce
+	}
+	return idToken, nil
+}
+
+// key returns the provider key named kid. Providers rotate keys by
+// publishing the new one first, so an unknown kid means the keys we have
+// are stale.
+func (c *Client) key(ctx context.Context, kid string) (interface{}, error) {
+	c.mu.Lock()
+	key, ok := c.lookupKey(kid)
+	stale := time.Since(c.keysFetched) >= minKeyRefresh
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	if err := c.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// lookupKey finds kid among the provider's keys. Tokens without a kid are
+// only accepted when the provider has a single key. c.mu must be held.
+func (c *Client) lookupKey(kid string) (interface{}, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+// refreshKeys fetches the provider's signing keys. Keys we can't use, like
+// encryption keys or unsupported types, are skipped rather than failing
+// the whole set.
+func (c *Client) refreshKeys(ctx context.Context) error {
+	var set auth.JWKSet
+	if err := c.getJSON(ctx, c.metadata.JWKSURI, &set); err != nil {
+		return fmt.Errorf("fetching provider keys: %w", err)
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys = keys
+	c.keysFetched = time.Now()
+	return nil
+}
+
+func (c *Client) getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
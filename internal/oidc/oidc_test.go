@@ -0,0 +1,203 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LoronsoDev/chirpy/internal/oidc/oidctest"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testRedirectURL = "https://chirpy.example/api/login/oidc/callback"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+func newTestClient(t *testing.T, clientSecret string) (*Client, *oidctest.Provider) {
+	t.Helper()
+	provider, err := oidctest.NewProvider("chirpy", clientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(provider.Close)
+
+	client, err := NewClient(context.Background(), Config{
+		Issuer:       provider.Issuer,
+		ClientID:     "chirpy",
+		ClientSecret: clientSecret,
+		RedirectURL:  testRedirectURL,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, provider
+}
+
+// authorize follows the authorization URL and returns the code the provider
+// redirected back with.
+func authorize(t *testing.T, client *Client, state, nonce string) string {
+	t.Helper()
+	noRedirects := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noRedirects.Get(client.AuthCodeURL(state, nonce, testVerifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected a redirect, got %s", resp.Status)
+	}
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(location.String(), testRedirectURL+"?") {
+		t.Fatalf("redirected to %s", location)
+	}
+	if got := location.Query().Get("state"); got != state {
+		t.Fatalf("expected state %q, got %q", state, got)
+	}
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Fatalf("no code in %s", location)
+	}
+	return code
+}
+
+func TestLogin(t *testing.T) {
+	for _, secret := range []string{"s3cret/+=", ""} {
+		client, provider := newTestClient(t, secret)
+		provider.SetUser(oidctest.User{Subject: "alice-1", Email: "Alice@Example.com", EmailVerified: true})
+
+		code := authorize(t, client, "state-1", "nonce-1")
+		rawToken, err := client.Exchange(context.Background(), code, testVerifier)
+		if err != nil {
+			t.Fatalf("secret %q: %v", secret, err)
+		}
+		idToken, err := client.VerifyIDToken(context.Background(), rawToken, "nonce-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if idToken.Subject != "alice-1" || idToken.Email != "Alice@Example.com" || !idToken.EmailVerified {
+			t.Errorf("unexpected ID token: %+v", idToken)
+		}
+
+		if _, err := client.Exchange(context.Background(), code, testVerifier); err == nil {
+			t.Error("expected a code to only be redeemable once")
+		}
+	}
+}
+
+func TestExchangeRequiresVerifier(t *testing.T) {
+	client, _ := newTestClient(t, "secret")
+	code := authorize(t, client, "state", "nonce")
+	if _, err := client.Exchange(context.Background(), code, strings.Repeat("a", 43)); err == nil {
+		t.Error("expected the wrong code verifier to be rejected")
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	client, provider := newTestClient(t, "secret")
+	user := oidctest.User{Subject: "bob", Email: "bob@example.com", EmailVerified: true}
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+		nonce  string
+		want   error
+	}{
+		{"wrong nonce", func(jwt.MapClaims) {}, "other", ErrNonce},
+		{"missing nonce", func(c jwt.MapClaims) { delete(c, "nonce") }, "nonce", ErrNonce},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "someone-else" }, "nonce", jwt.ErrTokenInvalidAudience},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }, "nonce", jwt.ErrTokenInvalidIssuer},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, "nonce", jwt.ErrTokenExpired},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }, "nonce", jwt.ErrTokenRequiredClaimMissing},
+		{"issued to another party", func(c jwt.MapClaims) {
+			c["aud"] = []string{"chirpy", "someone-else"}
+			c["azp"] = "someone-else"
+		}, "nonce", ErrInvalidIDToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := provider.IDTokenClaims(user, "nonce")
+			tt.modify(claims)
+			token, err := provider.SignIDToken(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.VerifyIDToken(context.Background(), token, tt.nonce); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	t.Run("unsigned", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodNone, provider.IDTokenClaims(user, "nonce")).
+			SignedString(jwt.UnsafeAllowNoneSignatureType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.VerifyIDToken(context.Background(), token, "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("expected unsigned tokens to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("signed with the client secret", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, provider.IDTokenClaims(user, "nonce"))
+		token.Header["kid"] = "mock-1"
+		signed, err := token.SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.VerifyIDToken(context.Background(), signed, "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("expected HS256 tokens to be rejected, got %v", err)
+		}
+	})
+}
+
+func TestVerifyIDTokenAfterKeyRotation(t *testing.T) {
+	client, provider := newTestClient(t, "secret")
+	user := oidctest.User{Subject: "carol", Email: "carol@example.com", EmailVerified: true}
+
+	if err := provider.RotateKey(); err != nil {
+		t.Fatal(err)
+	}
+	token, err := provider.SignIDToken(provider.IDTokenClaims(user, "nonce"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The keys were fetched moments ago, so the new kid isn't looked up
+	// until they're old enough to refresh.
+	if _, err := client.VerifyIDToken(context.Background(), token, "nonce"); err == nil {
+		t.Fatal("expected keys not to be refreshed this soon")
+	}
+	client.keysFetched = time.Now().Add(-minKeyRefresh)
+	if _, err := client.VerifyIDToken(context.Background(), token, "nonce"); err != nil {
+		t.Errorf("expected the rotated key to be fetched, got %v", err)
+	}
+}
+
+func TestNewClientChecksIssuer(t *testing.T) {
+	provider, err := oidctest.NewProvider("chirpy", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer provider.Close()
+
+	_, err = NewClient(context.Background(), Config{
+		Issuer:      provider.Issuer + "/",
+		ClientID:    "chirpy",
+		RedirectURL: testRedirectURL,
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "issuer") {
+		t.Errorf("expected an issuer mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package search
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// The SearchChirps query asks ts_headline to wrap matches in these
+// private-use characters (chr(57344) and chr(57345)) instead of HTML, so
+// the chirp text can be escaped before the real markup goes in.
+const (
+	startMark = "\uE000"
+	stopMark  = "\uE001"
+)
+
+// ErrEmptyQuery is returned when a query has nothing left to search for once
+// punctuation is stripped.
+var ErrEmptyQuery = errors.New("search query is empty")
+
+// ParseQuery turns what a user types in the search box into Postgres
+// to_tsquery syntax. Every term must match; "quoted text" matches the words
+// as a phrase and a trailing * (chirp*) matches any word with that prefix.
+// Only letters and digits make it into the output, so user input can't
+// inject tsquery operators.
+func ParseQuery(q string) (string, error) {
+	terms := []string{}
+
+	for len(q) > 0 {
+		var chunk string
+		isPhrase := false
+
+		q = strings.TrimLeftFunc(q, unicode.IsSpace)
+		if strings.HasPrefix(q, `"`) {
+			end := strings.Index(q[1:], `"`)
+			if end == -1 {
+				chunk, q = q[1:], ""
+			} else {
+				chunk, q = q[1:end+1], q[end+2:]
+			}
+			isPhrase = true
+		} else {
+			end := strings.IndexFunc(q, unicode.IsSpace)
+			if end == -1 {
+				chunk, q = q, ""
+			} else {
+				chunk, q = q[:end], q[end:]
+			}
+		}
+
+		if isPhrase {
+			if term := phraseTerm(chunk); term != "" {
+				terms = append(terms, term)
+			}
+			continue
+		}
+		terms = append(terms, wordTerms(chunk)...)
+	}
+
+	if len(terms) == 0 {
+		return "", ErrEmptyQuery
+	}
+	return strings.Join(terms, " & "), nil
+}
+
+// wordTerms handles an unquoted chunk. Punctuation inside it splits words,
+// so "don't" searches for "don" and "t" like the indexed text does.
+func wordTerms(chunk string) []string {
+	prefix := strings.HasSuffix(chunk, "*")
+	words := lexemes(chunk)
+
+	terms := []string{}
+	for i, word := range words {
+		term := "'" + word + "'"
+		if prefix && i == len(words)-1 {
+			term += ":*"
+		}
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+func phraseTerm(chunk string) string {
+	words := lexemes(chunk)
+	if len(words) == 0 {
+		return ""
+	}
+
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		quoted = append(quoted, "'"+word+"'")
+	}
+	if len(quoted) == 1 {
+		return quoted[0]
+	}
+	return "(" + strings.Join(quoted, " <-> ") + ")"
+}
+
+func lexemes(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Highlight escapes a ts_headline snippet for HTML and marks the matched
+// words with <mark> tags.
+func Highlight(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, startMark, "<mark>")
+	return strings.ReplaceAll(escaped, stopMark, "</mark>")
+}
@@ -0,0 +1,69 @@
+package search
+
+import "testing"
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "single word",
+			query:    "chirpy",
+			expected: "'chirpy'",
+		},
+		{
+			name:     "words are combined with and",
+			query:    "Hello  World",
+			expected: "'hello' & 'world'",
+		},
+		{
+			name:     "prefix match",
+			query:    "chirp*",
+			expected: "'chirp':*",
+		},
+		{
+			name:     "phrase match",
+			query:    `go "very fast" code*`,
+			expected: "'go' & ('very' <-> 'fast') & 'code':*",
+		},
+		{
+			name:     "unterminated phrase",
+			query:    `"very fast`,
+			expected: "('very' <-> 'fast')",
+		},
+		{
+			name:     "tsquery operators are stripped",
+			query:    "a & !b | c:* 'd'",
+			expected: "'a' & 'b' & 'c':* & 'd'",
+		},
+		{
+			name:        "empty query",
+			query:       `  "" !! `,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tsquery, err := ParseQuery(tt.query)
+			if (err != nil) != tt.expectError {
+				t.Errorf("expected error: %v, got error: %v", tt.expectError, err)
+			}
+			if tsquery != tt.expected {
+				t.Errorf("expected tsquery: %v, got tsquery: %v", tt.expected, tsquery)
+			}
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	snippet := "I <3 \uE000chirpy\uE001 & \uE000go\uE001"
+	expected := "I &lt;3 <mark>chirpy</mark> &amp; <mark>go</mark>"
+
+	if highlighted := Highlight(snippet); highlighted != expected {
+		t.Errorf("expected snippet: %v, got snippet: %v", expected, highlighted)
+	}
+}
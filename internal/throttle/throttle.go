@@ -0,0 +1,50 @@
+// Package throttle slows down repeated failures, like password guesses, with
+// an exponential backoff.
+package throttle
+
+import "time"
+
+// Policy says how long to wait after consecutive failures. The first Free
+// failures cost nothing; after that each one doubles the wait, starting at
+// Base and capped at Max. Failures are forgotten once there's been none for
+// Reset.
+type Policy struct {
+	Free  int
+	Base  time.Duration
+	Max   time.Duration
+	Reset time.Duration
+}
+
+// Delay is how long to wait after failures consecutive failures.
+func (p Policy) Delay(failures int) time.Duration {
+	if failures <= p.Free {
+		return 0
+	}
+	delay := p.Base
+	for i := p.Free + 1; i < failures; i++ {
+		delay *= 2
+		if delay >= p.Max {
+			return p.Max
+		}
+	}
+	return min(delay, p.Max)
+}
+
+// RetryAfter is how long from now until another attempt is allowed, given
+// the failures so far and when the last one happened. Zero means now.
+func (p Policy) RetryAfter(failures int, lastFailure, now time.Time) time.Duration {
+	if now.Sub(lastFailure) >= p.Reset {
+		return 0
+	}
+	wait := lastFailure.Add(p.Delay(failures)).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// ResetBefore is the time a last failure has to be older than for the count
+// to start over.
+func (p Policy) ResetBefore(now time.Time) time.Time {
+	return now.Add(-p.Reset)
+}
@@ -0,0 +1,61 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	Free:  3,
+	Base:  time.Second,
+	Max:   time.Minute,
+	Reset: time.Hour,
+}
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{9, 32 * time.Second},
+		{10, time.Minute},
+		{1 << 20, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := testPolicy.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	if got := testPolicy.RetryAfter(5, now.Add(-500*time.Millisecond), now); got != 1500*time.Millisecond {
+		t.Errorf("expected 1.5s left, got %v", got)
+	}
+	if got := testPolicy.RetryAfter(5, now.Add(-3*time.Second), now); got != 0 {
+		t.Errorf("expected the wait to be over, got %v", got)
+	}
+	if got := testPolicy.RetryAfter(3, now, now); got != 0 {
+		t.Errorf("expected free failures not to wait, got %v", got)
+	}
+	// Past the reset window the failures no longer count, even if the
+	// backoff on its own would still be running.
+	long := Policy{Free: 0, Base: 2 * time.Hour, Max: 2 * time.Hour, Reset: time.Hour}
+	if got := long.RetryAfter(1, now.Add(-time.Hour), now); got != 0 {
+		t.Errorf("expected old failures to be forgotten, got %v", got)
+	}
+}
+
+func TestResetBefore(t *testing.T) {
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	if got, want := testPolicy.ResetBefore(now), now.Add(-time.Hour); !got.Equal(want) {
+		t.Errorf("ResetBefore = %v, want %v", got, want)
+	}
+}
@@ -3,26 +3,8 @@ package main
 import (
 	"encoding/json"
 	"net/http"
-	"strings"
 )
 
-func getCleanBody(body string) (cleanedBody string) {
-	//Separate it into words
-	words := strings.Split(body, " ")
-	badWords := [3]string{"kerfuffle", "sharbert", "fornax"}
-
-	for i, word := range words {
-		word = strings.ToLower(word)
-		for _, badWord := range badWords {
-			if word == badWord {
-				words[i] = "****"
-			}
-		}
-	}
-
-	return strings.Join(words, " ")
-}
-
 func respondWithError(w http.ResponseWriter, code int, msg string) {
 	type errorParam struct {
 		Error string `json:"error"`
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/LoronsoDev/chirpy/internal/auth"
+	"github.com/LoronsoDev/chirpy/internal/database"
+	"github.com/LoronsoDev/chirpy/internal/throttle"
+)
+
+var (
+	// accountLoginPolicy slows down guessing one account's password, from
+	// however many addresses. A handful of typos are free.
+	accountLoginPolicy = throttle.Policy{
+		Free:  5,
+		Base:  30 * time.Second,
+		Max:   time.Hour,
+		Reset: 24 * time.Hour,
+	}
+	// ipLoginPolicy slows down one address trying many accounts. It's more
+	// lenient since many users can share an address behind a NAT.
+	ipLoginPolicy = throttle.Policy{
+		Free:  20,
+		Base:  10 * time.Second,
+		Max:   15 * time.Minute,
+		Reset: time.Hour,
+	}
+)
+
+var (
+	// errInvalidCredentials is the only answer to a wrong email or password,
+	// so logins can't be used to find out who is registered.
+	errInvalidCredentials   = errors.New("Invalid email or password")
+	errTooManyLoginAttempts = errors.New("Too many failed login attempts, try again later")
+)
+
+// dummyPasswordHash is checked against when there's no real hash to check,
+// so a login for an account that doesn't exist takes as long as one with the
+// wrong password.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := auth.HashPassword("not anyone's password")
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+func accountThrottleKey(email string) string {
+	return "account:" + email
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+type loginThrottle struct {
+	key    string
+	policy throttle.Policy
+}
+
+// loginThrottles are the counts a login attempt goes against, always in this
+// order so concurrent attempts lock their rows the same way round.
+func loginThrottles(email, ip string) []loginThrottle {
+	return []loginThrottle{
+		{accountThrottleKey(email), accountLoginPolicy},
+		{ipThrottleKey(ip), ipLoginPolicy},
+	}
+}
+
+// reserveLoginAttempt counts an attempt as failed before its password is
+// checked, with the rows locked between the check and the count, so parallel
+// guesses can't all slip in under the limit. When the account or the client
+// has to wait, nothing is counted and the wait is returned instead.
+func (apiCfg *apiConfig) reserveLoginAttempt(ctx context.Context, email, ip string) (time.Duration, error) {
+	var wait time.Duration
+	now := time.Now().UTC()
+	err := apiCfg.withTx(ctx, func(qtx *database.Queries) error {
+		throttles := loginThrottles(email, ip)
+		for _, t := range throttles {
+			row, err := qtx.LockLoginThrottle(ctx, t.key)
+			if err != nil {
+				return err
+			}
+			wait = max(wait, t.policy.RetryAfter(int(row.Failures), row.LastFailureAt, now))
+		}
+		if wait > 0 {
+			return nil
+		}
+		for _, t := range throttles {
+			err := qtx.RecordLoginFailure(ctx, database.RecordLoginFailureParams{
+				Key:         t.key,
+				ResetBefore: t.policy.ResetBefore(now),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return wait, err
+}
+
+// releaseLoginAttempt takes back the attempt reserved for a login that got
+// the password right. Only the account's failures are forgotten: an attacker
+// who owns one account shouldn't be able to reset their address's count with
+// it, so the address just gets its one attempt back.
+func (apiCfg *apiConfig) releaseLoginAttempt(ctx context.Context, email, ip string) error {
+	return apiCfg.withTx(ctx, func(qtx *database.Queries) error {
+		if _, err := qtx.ClearLoginThrottle(ctx, accountThrottleKey(email)); err != nil {
+			return err
+		}
+		return qtx.ForgetLoginFailure(ctx, ipThrottleKey(ip))
+	})
+}
+
+// passwordMatches checks a login's password. found is false when no user has
+// the email; the check then runs against a dummy hash, as it does for users
+// who only sign in with an identity provider, to take the same time.
+func passwordMatches(user database.User, found bool, password string) bool {
+	if !found || user.HashedPassword == "" {
+		auth.CheckPasswordHash(password, dummyPasswordHash())
+		return false
+	}
+	return auth.CheckPasswordHash(password, user.HashedPassword) == nil
+}
+
+func respondTooManyLoginAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, errTooManyLoginAttempts.Error())
+}
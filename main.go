@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/LoronsoDev/chirpy/internal/auth"
 	"github.com/LoronsoDev/chirpy/internal/database"
+	"github.com/LoronsoDev/chirpy/internal/mail"
+	"github.com/LoronsoDev/chirpy/internal/moderation"
+	"github.com/LoronsoDev/chirpy/internal/oidc"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
@@ -15,8 +23,20 @@ import (
 type apiConfig struct {
 	fileserverHits int
 	db             *database.Queries
-	jwtSecret      string
+	sqlDB          *sql.DB
+	jwtKeys        *auth.KeyManager
 	polkaKey       string
+	cursorSecret   string
+	moderator      moderation.Filter
+	mailer         mail.Mailer
+	publicURL      string
+	// oidcClient is nil unless single sign-on is configured.
+	oidcClient *oidc.Client
+	// adminEmails are made admins by promoteAdmins.
+	adminEmails []string
+	// requireVerifiedEmail stops users from posting until they verify
+	// their email address.
+	requireVerifiedEmail bool
 }
 
 func main() {
@@ -32,6 +52,12 @@ func main() {
 	jwtSecret := os.Getenv("JWT_SECRET")
 	dbURL := os.Getenv("DB_URL")
 
+	// Pagination cursors are signed so clients can't craft arbitrary positions.
+	cursorSecret := os.Getenv("CURSOR_SECRET")
+	if cursorSecret == "" {
+		cursorSecret = jwtSecret
+	}
+
 	serveMux := http.NewServeMux()
 	// handler := http.FileServer(http.Dir(filepathRoot))
 
@@ -46,28 +72,165 @@ func main() {
 
 	dbQueries := database.New(db)
 
+	rules, err := loadModerationFilter(context.Background(), dbQueries)
+	if err != nil {
+		log.Fatalf("Error loading moderation rules: %v", err)
+	}
+	// Rules are reloaded every MODERATION_RELOAD_INTERVAL, a minute by
+	// default.
+	moderationReload := time.Minute
+	if v := os.Getenv("MODERATION_RELOAD_INTERVAL"); v != "" {
+		moderationReload, err = time.ParseDuration(v)
+		if err != nil || moderationReload <= 0 {
+			log.Fatalf("Invalid MODERATION_RELOAD_INTERVAL %q", v)
+		}
+	}
+	moderator := moderation.NewReloadable(rules)
+	go reloadModerationRules(context.Background(), dbQueries, moderator, moderationReload)
+
+	// Tokens are signed with the shared secret unless a directory of PEM
+	// keys is configured, in which case the public halves are published at
+	// /.well-known/jwks.json so keys can be rotated without downtime.
+	jwtKeys := auth.NewHMACKeyManager(jwtSecret)
+	if keysDir := os.Getenv("JWT_KEYS_DIR"); keysDir != "" {
+		jwtKeys, err = auth.LoadKeyManager(keysDir, os.Getenv("JWT_ACTIVE_KEY_ID"))
+		if err != nil {
+			log.Fatalf("Error loading JWT signing keys: %v", err)
+		}
+	}
+
+	validator, err := loadTokenValidator()
+	if err != nil {
+		log.Fatalf("Error configuring token validation: %v", err)
+	}
+	if err := jwtKeys.SetValidator(validator); err != nil {
+		log.Fatalf("Error configuring token validation: %v", err)
+	}
+
+	mailer, err := loadMailer()
+	if err != nil {
+		log.Fatalf("Error configuring mailer: %v", err)
+	}
+	// Links in emails point here.
+	publicURL := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+	if publicURL == "" {
+		publicURL = "http://localhost:" + port
+	}
+
+	oidcClient, err := loadOIDCClient(context.Background(), publicURL)
+	if err != nil {
+		log.Fatalf("Error configuring single sign-on: %v", err)
+	}
+
+	requireVerifiedEmail := true
+	if v := os.Getenv("REQUIRE_VERIFIED_EMAIL"); v != "" {
+		requireVerifiedEmail, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid REQUIRE_VERIFIED_EMAIL %q", v)
+		}
+	}
+
 	cfg := apiConfig{
 		db:             dbQueries,
-		jwtSecret:      jwtSecret,
+		sqlDB:          db,
+		jwtKeys:        jwtKeys,
 		fileserverHits: 0,
 		polkaKey:       os.Getenv("POLKA_KEY"),
+		cursorSecret:   cursorSecret,
+		moderator:      moderator,
+		mailer:         mailer,
+		publicURL:      publicURL,
+		oidcClient:     oidcClient,
+		adminEmails:    loadAdminEmails(),
+
+		requireVerifiedEmail: requireVerifiedEmail,
+	}
+	if _, err := cfg.promoteAdmins(context.Background()); err != nil {
+		log.Fatalf("Error promoting ADMIN_EMAILS: %v", err)
 	}
 
 	// serveMux.Handle("/app/", http.StripPrefix("/app", cfg.middlewareMetricsInc(handler)))
+	// Reset has to work on an empty database, where nobody can hold a role,
+	// so it is limited to dev environments instead.
 	serveMux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	serveMux.HandleFunc("GET /admin/reports", cfg.middlewareRequireRole(auth.RoleModerator, cfg.handlerGetReports))
+	serveMux.HandleFunc("POST /admin/reports/{reportID}/dismiss", cfg.middlewareRequireRole(auth.RoleModerator, cfg.handlerDismissReport))
+	serveMux.HandleFunc("POST /admin/chirps/{chirpID}/hide", cfg.middlewareRequireRole(auth.RoleModerator, cfg.handlerHideChirp))
+	serveMux.HandleFunc("POST /admin/chirps/{chirpID}/restore", cfg.middlewareRequireRole(auth.RoleModerator, cfg.handlerRestoreChirp))
+	serveMux.HandleFunc("POST /admin/users/{userID}/suspend", cfg.middlewareRequireRole(auth.RoleModerator, cfg.handlerSuspendUser))
+	serveMux.HandleFunc("DELETE /admin/users/{userID}/suspend", cfg.middlewareRequireRole(auth.RoleModerator, cfg.handlerUnsuspendUser))
+	serveMux.HandleFunc("POST /admin/users/{userID}/unlock", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerUnlockUser))
+	serveMux.HandleFunc("PUT /admin/users/{userID}/role", cfg.middlewareRequireRole(auth.RoleAdmin, cfg.handlerSetUserRole))
 
 	serveMux.HandleFunc("POST /api/users", cfg.handlerNewUser)
-	serveMux.HandleFunc("PUT /api/users", cfg.handlerUpdateCredentials)
+	serveMux.HandleFunc("PUT /api/users", cfg.middlewareRequireAuth(cfg.handlerUpdateCredentials))
+	serveMux.HandleFunc("POST /api/users/{userID}/follow", cfg.middlewareRequireAuth(cfg.handlerFollowUser))
+	serveMux.HandleFunc("DELETE /api/users/{userID}/follow", cfg.middlewareRequireAuth(cfg.handlerUnfollowUser))
+	serveMux.HandleFunc("GET /api/users/{userID}/followers", cfg.handlerGetFollowers)
+	serveMux.HandleFunc("GET /api/users/{userID}/following", cfg.handlerGetFollowing)
 
+	serveMux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerGetJWKS)
 	serveMux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	serveMux.HandleFunc("POST /api/login/mfa", cfg.handlerLoginMFA)
+	serveMux.HandleFunc("GET /api/login/oidc", cfg.handlerStartOIDCLogin)
+	serveMux.HandleFunc("GET /api/login/oidc/callback", cfg.handlerOIDCCallback)
+
+	serveMux.HandleFunc("POST /api/2fa/enroll", cfg.middlewareRequireAuth(cfg.handlerEnrollTOTP))
+	serveMux.HandleFunc("POST /api/2fa/confirm", cfg.middlewareRequireAuth(cfg.handlerConfirmTOTP))
+	serveMux.HandleFunc("DELETE /api/2fa", cfg.middlewareRequireAuth(cfg.handlerDisableTOTP))
+
+	serveMux.HandleFunc("GET /api/verify", cfg.handlerVerifyEmail)
+	serveMux.HandleFunc("POST /api/verify/resend", cfg.middlewareRequireAuth(cfg.handlerResendVerification))
+
+	serveMux.HandleFunc("POST /api/password/forgot", cfg.handlerForgotPassword)
+	serveMux.HandleFunc("POST /api/password/reset", cfg.handlerResetPassword)
 
 	serveMux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	serveMux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
 
-	serveMux.HandleFunc("GET /api/chirps", cfg.handlerGetAllChirps)
-	serveMux.HandleFunc("GET /api/chirps/{chirpID}", cfg.handlerGetSpecificChirp)
-	serveMux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.handlerDeleteChirp)
-	serveMux.HandleFunc("POST /api/chirps", cfg.handlerNewChirp)
+	// API keys are managed with a login, never with another key.
+	serveMux.HandleFunc("GET /api/keys", cfg.middlewareRequireAuth(cfg.handlerGetAPIKeys))
+	serveMux.HandleFunc("POST /api/keys", cfg.middlewareRequireAuth(cfg.handlerCreateAPIKey))
+	serveMux.HandleFunc("DELETE /api/keys/{keyID}", cfg.middlewareRequireAuth(cfg.handlerRevokeAPIKey))
+
+	// Apps acting on behalf of users. The authorize endpoints are called by
+	// our frontend with the user's login; the rest by the apps themselves.
+	serveMux.HandleFunc("GET /api/oauth/clients", cfg.middlewareRequireAuth(cfg.handlerGetOAuthClients))
+	serveMux.HandleFunc("POST /api/oauth/clients", cfg.middlewareRequireAuth(cfg.handlerCreateOAuthClient))
+	serveMux.HandleFunc("DELETE /api/oauth/clients/{clientID}", cfg.middlewareRequireAuth(cfg.handlerDeleteOAuthClient))
+	serveMux.HandleFunc("GET /api/oauth/authorize", cfg.middlewareRequireAuth(cfg.handlerGetOAuthAuthorization))
+	serveMux.HandleFunc("POST /api/oauth/authorize", cfg.middlewareRequireAuth(cfg.handlerOAuthAuthorize))
+	serveMux.HandleFunc("POST /api/oauth/token", cfg.handlerOAuthToken)
+	serveMux.HandleFunc("POST /api/oauth/introspect", cfg.handlerOAuthIntrospect)
+	serveMux.HandleFunc("POST /api/oauth/revoke", cfg.handlerOAuthRevoke)
+	serveMux.HandleFunc("GET /api/oauth/consents", cfg.middlewareRequireAuth(cfg.handlerGetOAuthConsents))
+	serveMux.HandleFunc("DELETE /api/oauth/consents/{clientID}", cfg.middlewareRequireAuth(cfg.handlerRevokeOAuthConsent))
+
+	serveMux.HandleFunc("GET /api/sessions", cfg.middlewareRequireAuth(cfg.handlerGetSessions))
+	serveMux.HandleFunc("DELETE /api/sessions", cfg.middlewareRequireAuth(cfg.handlerRevokeAllSessions))
+	serveMux.HandleFunc("DELETE /api/sessions/{sessionID}", cfg.middlewareRequireAuth(cfg.handlerRevokeSession))
+
+	serveMux.HandleFunc("GET /api/chirps", cfg.middlewareOptionalScope(auth.ScopeChirpsRead, cfg.handlerGetAllChirps))
+	serveMux.HandleFunc("GET /api/chirps/search", cfg.middlewareOptionalScope(auth.ScopeChirpsRead, cfg.handlerSearchChirps))
+	serveMux.HandleFunc("GET /api/chirps/{chirpID}", cfg.middlewareOptionalScope(auth.ScopeChirpsRead, cfg.handlerGetSpecificChirp))
+	serveMux.HandleFunc("PUT /api/chirps/{chirpID}", cfg.middlewareRequireScope(auth.ScopeChirpsWrite, cfg.handlerEditChirp))
+	serveMux.HandleFunc("DELETE /api/chirps/{chirpID}", cfg.middlewareRequireScope(auth.ScopeChirpsWrite, cfg.handlerDeleteChirp))
+	serveMux.HandleFunc("GET /api/chirps/{chirpID}/revisions", cfg.middlewareOptionalScope(auth.ScopeChirpsRead, cfg.handlerGetChirpRevisions))
+	serveMux.HandleFunc("GET /api/chirps/{chirpID}/thread", cfg.middlewareOptionalScope(auth.ScopeChirpsRead, cfg.handlerGetThread))
+	serveMux.HandleFunc("POST /api/chirps/{chirpID}/like", cfg.middlewareRequireAuth(cfg.handlerLikeChirp))
+	serveMux.HandleFunc("DELETE /api/chirps/{chirpID}/like", cfg.middlewareRequireAuth(cfg.handlerUnlikeChirp))
+	serveMux.HandleFunc("POST /api/chirps/{chirpID}/rechirp", cfg.middlewareRequireScope(auth.ScopeChirpsWrite, cfg.handlerRechirp))
+	serveMux.HandleFunc("DELETE /api/chirps/{chirpID}/rechirp", cfg.middlewareRequireScope(auth.ScopeChirpsWrite, cfg.handlerUndoRechirp))
+	serveMux.HandleFunc("POST /api/chirps/{chirpID}/report", cfg.middlewareRequireAuth(cfg.handlerReportChirp))
+	serveMux.HandleFunc("POST /api/chirps", cfg.middlewareRequireScope(auth.ScopeChirpsWrite, cfg.handlerNewChirp))
+
+	serveMux.HandleFunc("GET /api/timeline", cfg.middlewareRequireScope(auth.ScopeChirpsRead, cfg.handlerGetTimeline))
+
+	serveMux.HandleFunc("GET /api/notifications", cfg.middlewareRequireAuth(cfg.handlerGetNotifications))
+	serveMux.HandleFunc("POST /api/notifications/read", cfg.middlewareRequireAuth(cfg.handlerMarkNotificationsRead))
+
+	serveMux.HandleFunc("GET /api/tags/trending", cfg.handlerGetTrendingTags)
+	serveMux.HandleFunc("GET /api/tags/{tag}/chirps", cfg.middlewareOptionalScope(auth.ScopeChirpsRead, cfg.handlerGetTagChirps))
 
 	// Webhooks...
 	serveMux.HandleFunc("POST /api/polka/webhooks", cfg.handlerPolkaWebhook)
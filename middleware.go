@@ -1,8 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
+
+	"github.com/LoronsoDev/chirpy/internal/auth"
+	"github.com/LoronsoDev/chirpy/internal/database"
+	"github.com/google/uuid"
 )
 
 func middlewareLog(next http.Handler) http.Handler {
@@ -18,3 +26,192 @@ func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+type contextKey string
+
+const (
+	claimsContextKey contextKey = "claims"
+	userContextKey   contextKey = "user"
+)
+
+// respondUnauthorized answers a request whose credentials are missing or
+// sent with the wrong scheme, telling the client which scheme to use.
+// errCode is one of the RFC 6750 codes, or empty when no credentials were
+// sent at all. Rejected tokens go through respondInvalidToken instead.
+func respondUnauthorized(w http.ResponseWriter, errCode string) {
+	challenge := `Bearer realm="chirpy"`
+	msg := "Authentication required"
+	if errCode == "invalid_request" {
+		challenge += `, error="invalid_request"`
+		msg = "Authorization header must use the Bearer or ApiKey scheme"
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	respondWithError(w, http.StatusUnauthorized, msg)
+}
+
+// tokenErrors are the reasons a token can be rejected, checked in order.
+// The code lets clients tell an expired token, which they should refresh,
+// from one they should throw away.
+var tokenErrors = []struct {
+	err  error
+	code string
+	msg  string
+}{
+	{auth.ErrTokenExpired, "token_expired", "Token has expired"},
+	{auth.ErrTokenRevoked, "token_revoked", "Token has been revoked"},
+	{auth.ErrTokenNotValidYet, "token_not_yet_valid", "Token is not valid yet"},
+	{auth.ErrTokenAudience, "wrong_audience", "Token was not issued for this API"},
+	{auth.ErrTokenIssuer, "wrong_issuer", "Token was not issued by chirpy"},
+	{auth.ErrTokenAlgorithm, "unsupported_algorithm", "Token signing algorithm is not allowed"},
+	{auth.ErrTokenSignature, "bad_signature", "Token signature is invalid"},
+}
+
+// respondInvalidToken rejects a bearer token, with the reason in both the
+// challenge and the response body.
+func respondInvalidToken(w http.ResponseWriter, err error) {
+	code, msg := "invalid_token", "Invalid token"
+	for _, te := range tokenErrors {
+		if errors.Is(err, te.err) {
+			code, msg = te.code, te.msg
+			break
+		}
+	}
+
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="chirpy", error="invalid_token", error_description=%q`, msg))
+	respondWithJSON(w, http.StatusUnauthorized, struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}{
+		Error: msg,
+		Code:  code,
+	})
+}
+
+// middlewareRequireAuth validates the caller's access token and makes its
+// claims available to next through claimsFromContext. API keys and tokens
+// issued to OAuth clients aren't accepted; endpoints that take them use
+// middlewareRequireScope.
+func (cfg *apiConfig) middlewareRequireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return cfg.authenticate(true, "", next)
+}
+
+// middlewareOptionalAuth is for endpoints anyone can call but that show more
+// to logged-in users, like liked_by_me. Requests without an Authorization
+// header go through anonymously; a bad token is still rejected so clients
+// notice it expired instead of silently getting anonymous responses.
+func (cfg *apiConfig) middlewareOptionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	return cfg.authenticate(false, "", next)
+}
+
+// middlewareRequireScope is middlewareRequireAuth for endpoints that also
+// accept API keys and OAuth tokens with the given scope.
+func (cfg *apiConfig) middlewareRequireScope(scope auth.Scope, next http.HandlerFunc) http.HandlerFunc {
+	return cfg.authenticate(true, scope, next)
+}
+
+// middlewareOptionalScope is middlewareOptionalAuth for endpoints that also
+// accept API keys and OAuth tokens with the given scope.
+func (cfg *apiConfig) middlewareOptionalScope(scope auth.Scope, next http.HandlerFunc) http.HandlerFunc {
+	return cfg.authenticate(false, scope, next)
+}
+
+// authenticate checks the Authorization header, either a Bearer access token
+// or, when scope is set, an ApiKey with that scope.
+func (cfg *apiConfig) authenticate(required bool, scope auth.Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			if required {
+				respondUnauthorized(w, "")
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		var claims auth.Claims
+		if strings.HasPrefix(authHeader, "ApiKey ") {
+			key, err := auth.GetAPIKey(r.Header)
+			if err != nil {
+				respondUnauthorized(w, "invalid_request")
+				return
+			}
+			claims, err = cfg.authenticateAPIKey(r.Context(), key)
+			if err != nil {
+				respondInvalidAPIKey(w, err)
+				return
+			}
+		} else {
+			token, err := auth.GetBearerToken(r.Header)
+			if err != nil {
+				respondUnauthorized(w, "invalid_request")
+				return
+			}
+			claims, err = cfg.jwtKeys.ParseJWT(token, cfg.checkTokenVersion(r.Context()), cfg.checkSessionActive(r.Context()), cfg.checkOAuthGrant(r.Context()))
+			if err != nil {
+				respondInvalidToken(w, err)
+				return
+			}
+		}
+		if _, err := claims.UserID(); err != nil {
+			respondInvalidToken(w, auth.ErrTokenMalformed)
+			return
+		}
+		if claims.Scoped() && (scope == "" || !claims.Allows(scope)) {
+			respondInsufficientScope(w, scope)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims)))
+	}
+}
+
+func claimsFromContext(ctx context.Context) (auth.Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(auth.Claims)
+	return claims, ok
+}
+
+// userIDFromContext is the authenticated caller. Handlers behind
+// middlewareRequireAuth can rely on it being set.
+func userIDFromContext(ctx context.Context) uuid.UUID {
+	claims, ok := claimsFromContext(ctx)
+	if !ok {
+		return uuid.Nil
+	}
+	userID, _ := claims.UserID()
+	return userID
+}
+
+// viewerFromContext is the caller on endpoints behind middlewareOptionalAuth,
+// invalid for anonymous requests.
+func viewerFromContext(ctx context.Context) uuid.NullUUID {
+	userID := userIDFromContext(ctx)
+	return uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil}
+}
+
+// middlewareRequireRole only lets through callers whose token grants at least
+// role. The stored role is checked as well, so revoking a role takes effect
+// before the user's tokens expire. The caller is available to next through
+// userFromContext.
+func (cfg *apiConfig) middlewareRequireRole(role auth.Role, next http.HandlerFunc) http.HandlerFunc {
+	return cfg.middlewareRequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := claimsFromContext(r.Context())
+		if !claims.Role.Includes(role) {
+			respondWithError(w, http.StatusForbidden, "You don't have permission to do this")
+			return
+		}
+
+		user, err := cfg.db.GetUser(r.Context(), userIDFromContext(r.Context()))
+		if err != nil || !auth.Role(user.Role).Includes(role) {
+			respondWithError(w, http.StatusForbidden, "You don't have permission to do this")
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
+	})
+}
+
+func userFromContext(ctx context.Context) database.User {
+	user, _ := ctx.Value(userContextKey).(database.User)
+	return user
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/LoronsoDev/chirpy/internal/auth"
+	"github.com/LoronsoDev/chirpy/internal/database"
+	"github.com/LoronsoDev/chirpy/internal/mail"
+	"github.com/LoronsoDev/chirpy/internal/moderation"
+	"github.com/google/uuid"
+)
+
+const maxChirpLength = 140
+
+var (
+	errChirpTooLong  = errors.New("Chirp is too long")
+	errChirpRejected = errors.New("Chirp contains words that aren't allowed")
+	errUserSuspended = errors.New("Your account is suspended")
+)
+
+// loadModerationFilter builds the word filter from the built-in list, the
+// file named by MODERATION_WORDS_FILE and the moderation_rules table, in
+// that order, so a rule in the database overrides the same word elsewhere.
+func loadModerationFilter(ctx context.Context, db *database.Queries) (moderation.Filter, error) {
+	rules := append([]moderation.Rule{}, moderation.DefaultRules...)
+
+	if path := os.Getenv("MODERATION_WORDS_FILE"); path != "" {
+		fileRules, err := moderation.LoadRulesFile(path)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, fileRules...)
+	}
+
+	// Starting without the database's rules would let through everything
+	// they reject or flag.
+	dbRules, err := db.ListModerationRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, dbRule := range dbRules {
+		action, err := moderation.ParseAction(dbRule.Action)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, moderation.Rule{Word: dbRule.Word, Action: action})
+	}
+
+	return moderation.Pipeline{moderation.NewWordFilter(rules)}, nil
+}
+
+// reloadModerationRules loads the rules again every interval, so rules
+// changed in the database apply without a restart. If they can't be loaded,
+// the rules already in use stay in place.
+func reloadModerationRules(ctx context.Context, db *database.Queries, filter *moderation.Reloadable, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		rules, err := loadModerationFilter(ctx, db)
+		if err != nil {
+			log.Printf("Error reloading moderation rules: %v", err)
+			continue
+		}
+		filter.Swap(rules)
+	}
+}
+
+// cleanChirpBody applies the checks every chirp body goes through, whether
+// it's being created or edited.
+func (apiCfg *apiConfig) cleanChirpBody(body string) (moderation.Verdict, error) {
+	if len(body) > maxChirpLength {
+		return moderation.Verdict{}, errChirpTooLong
+	}
+	verdict := apiCfg.moderator.Apply(body)
+	if verdict.Rejected {
+		return moderation.Verdict{}, errChirpRejected
+	}
+	return verdict, nil
+}
+
+// respondWithChirpBodyError picks the status for an error from cleanChirpBody.
+func respondWithChirpBodyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errChirpRejected) {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	respondWithError(w, http.StatusBadRequest, err.Error())
+}
+
+// flagChirp queues a chirp for review if the moderator flagged its body.
+func flagChirp(ctx context.Context, qtx *database.Queries, chirpID uuid.UUID, verdict moderation.Verdict) error {
+	if !verdict.Flagged {
+		return nil
+	}
+	return qtx.AddChirpFlags(ctx, database.AddChirpFlagsParams{
+		ChirpID: chirpID,
+		Words:   verdict.FlaggedWords(),
+	})
+}
+
+// loadAdminEmails reads ADMIN_EMAILS, a comma separated list of addresses
+// whose accounts are made admins once the address is verified. It's how a
+// new deployment gets its first admin, who can then grant roles through the
+// API.
+func loadAdminEmails() []string {
+	emails := []string{}
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = mail.CanonicalAddress(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
+
+// promoteAdmins makes admins of the users in ADMIN_EMAILS who have verified
+// their address, or of just those among emails when any are given, and
+// returns how many it promoted. Users who are already admins are left alone.
+func (apiCfg *apiConfig) promoteAdmins(ctx context.Context, emails ...string) (int64, error) {
+	if len(emails) > 0 {
+		emails = slices.DeleteFunc(emails, func(email string) bool {
+			return !slices.Contains(apiCfg.adminEmails, email)
+		})
+	} else {
+		emails = apiCfg.adminEmails
+	}
+	if len(emails) == 0 {
+		return 0, nil
+	}
+	promoted, err := apiCfg.db.PromoteAdmins(ctx, emails)
+	if err != nil {
+		return 0, err
+	}
+	if promoted > 0 {
+		log.Printf("Made %d user(s) admins from ADMIN_EMAILS", promoted)
+	}
+	return promoted, nil
+}
+
+// isModerator checks both the caller's claims and the stored role, like
+// middlewareRequireRole, so API keys of moderators see what any user sees.
+func (apiCfg *apiConfig) isModerator(ctx context.Context, viewerID uuid.NullUUID) bool {
+	if !viewerID.Valid {
+		return false
+	}
+	if claims, ok := claimsFromContext(ctx); ok && !claims.Role.Includes(auth.RoleModerator) {
+		return false
+	}
+	user, err := apiCfg.db.GetUser(ctx, viewerID.UUID)
+	return err == nil && auth.Role(user.Role).Includes(auth.RoleModerator)
+}
+
+// canSeeChirp hides chirps a moderator took down from everyone but their
+// author and other moderators.
+func (apiCfg *apiConfig) canSeeChirp(ctx context.Context, dbChirp database.Chirp, viewerID uuid.NullUUID) bool {
+	if !dbChirp.HiddenAt.Valid {
+		return true
+	}
+	if viewerID.Valid && viewerID.UUID == dbChirp.UserID {
+		return true
+	}
+	return apiCfg.isModerator(ctx, viewerID)
+}
+
+// checkCanPost is checkNotSuspended for writing chirp bodies, new or edited,
+// which also needs a verified email address when REQUIRE_VERIFIED_EMAIL is
+// on.
+func (apiCfg *apiConfig) checkCanPost(ctx context.Context, userID uuid.UUID) error {
+	user, err := apiCfg.db.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.SuspendedAt.Valid {
+		return errUserSuspended
+	}
+	if apiCfg.requireVerifiedEmail && !user.EmailVerified {
+		return errEmailNotVerified
+	}
+	return nil
+}
+
+// checkNotSuspended stops suspended users from publishing anything.
+func (apiCfg *apiConfig) checkNotSuspended(ctx context.Context, userID uuid.UUID) error {
+	user, err := apiCfg.db.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.SuspendedAt.Valid {
+		return errUserSuspended
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/LoronsoDev/chirpy/internal/chirptext"
+	"github.com/LoronsoDev/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	notificationMention = "mention"
+	notificationReply   = "reply"
+	notificationLike    = "like"
+)
+
+// notifyNewChirp records the users a new chirp mentions and notifies them,
+// along with the author of the chirp it replies to, if any. Authors are
+// never notified about their own chirps.
+func notifyNewChirp(ctx context.Context, qtx *database.Queries, dbChirp database.Chirp, replyToAuthor uuid.NullUUID) error {
+	mentioned, err := resolveMentions(ctx, qtx, dbChirp.Body)
+	if err != nil {
+		return err
+	}
+
+	if len(mentioned) > 0 {
+		err = qtx.AddMentions(ctx, database.AddMentionsParams{
+			ChirpID: dbChirp.ID,
+			UserIds: mentioned,
+		})
+		if err != nil {
+			return err
+		}
+		err = qtx.AddNotifications(ctx, database.AddNotificationsParams{
+			ActorID: dbChirp.UserID,
+			Kind:    notificationMention,
+			ChirpID: dbChirp.ID,
+			UserIds: mentioned,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if replyToAuthor.Valid {
+		return qtx.AddNotifications(ctx, database.AddNotificationsParams{
+			ActorID: dbChirp.UserID,
+			Kind:    notificationReply,
+			ChirpID: dbChirp.ID,
+			UserIds: []uuid.UUID{replyToAuthor.UUID},
+		})
+	}
+	return nil
+}
+
+// resolveMentions maps the mentions in a body to user IDs. Emails match
+// exactly; a handle only counts when a single user's email starts with it,
+// since nothing else makes handles unique.
+func resolveMentions(ctx context.Context, qtx *database.Queries, body string) ([]uuid.UUID, error) {
+	emails := []string{}
+	handles := []string{}
+	for _, mention := range chirptext.Mentions(body) {
+		if mention.Email != "" {
+			emails = append(emails, mention.Email)
+		} else {
+			handles = append(handles, mention.Handle)
+		}
+	}
+	if len(emails) == 0 && len(handles) == 0 {
+		return nil, nil
+	}
+
+	candidates, err := qtx.ResolveMentions(ctx, database.ResolveMentionsParams{
+		Emails:  emails,
+		Handles: handles,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mentionedEmails := map[string]bool{}
+	for _, email := range emails {
+		mentionedEmails[email] = true
+	}
+	byHandle := map[string][]uuid.UUID{}
+	userIDs := []uuid.UUID{}
+	seen := map[uuid.UUID]bool{}
+
+	for _, candidate := range candidates {
+		email := strings.ToLower(candidate.Email)
+		if mentionedEmails[email] && !seen[candidate.ID] {
+			seen[candidate.ID] = true
+			userIDs = append(userIDs, candidate.ID)
+		}
+		handle, _, _ := strings.Cut(email, "@")
+		byHandle[handle] = append(byHandle[handle], candidate.ID)
+	}
+	for _, handle := range handles {
+		matches := byHandle[handle]
+		if len(matches) == 1 && !seen[matches[0]] {
+			seen[matches[0]] = true
+			userIDs = append(userIDs, matches[0])
+		}
+	}
+	return userIDs, nil
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/LoronsoDev/chirpy/internal/auth"
+	"github.com/LoronsoDev/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxOAuthClientNameLength = 100
+	maxRedirectURIs          = 10
+	oauthCodeTTL             = 10 * time.Minute
+	oauthAccessTokenTTL      = time.Hour
+)
+
+// oauthError is an error response as defined by RFC 6749, with a code
+// clients can act on.
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *oauthError) Error() string {
+	return e.Description
+}
+
+func newOAuthError(code, description string) *oauthError {
+	return &oauthError{Code: code, Description: description}
+}
+
+var (
+	errUnknownOAuthClient   = errors.New("Unknown client")
+	errUnregisteredRedirect = errors.New("redirect_uri isn't registered for this client")
+	errInvalidOAuthClient   = newOAuthError("invalid_client", "Unknown client or bad client credentials")
+	errInvalidOAuthGrant    = newOAuthError("invalid_grant", "Authorization code or refresh token is invalid, expired or revoked")
+)
+
+// respondWithOAuthError sends err as the JSON body the token, introspection
+// and revocation endpoints use for errors.
+func respondWithOAuthError(w http.ResponseWriter, err error) {
+	var oerr *oauthError
+	if !errors.As(err, &oerr) {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	code := http.StatusBadRequest
+	if oerr.Code == "invalid_client" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		code = http.StatusUnauthorized
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, oerr)
+}
+
+// OAuthClient is an app registered to act on behalf of users, as shown to
+// its owner. The secret is only in the response that created the client.
+type OAuthClient struct {
+	ID           uuid.UUID `json:"id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	Secret       string    `json:"client_secret,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func newOAuthClientResponse(client database.OauthClient) OAuthClient {
+	return OAuthClient{
+		ID:           client.ID,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectUris,
+		Confidential: client.SecretHash.Valid,
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// checkRedirectURI only accepts absolute https URIs, or http ones on the
+// loopback interface for apps running on the user's machine. Authorization
+// codes are sent there, so they can't go over plain http to anyone else.
+func checkRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("redirect URI %q must be an absolute URL", raw)
+	}
+	if u.Fragment != "" {
+		return fmt.Errorf("redirect URI %q can't have a fragment", raw)
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if host := u.Hostname(); host == "localhost" || host == "127.0.0.1" || host == "::1" {
+			return nil
+		}
+	}
+	return fmt.Errorf("redirect URI %q must use https", raw)
+}
+
+// authorizationRequest is a validated request for an authorization code,
+// from the query string of /api/oauth/authorize.
+type authorizationRequest struct {
+	Client        database.OauthClient
+	RedirectURI   string
+	State         string
+	Scopes        []auth.Scope
+	CodeChallenge string
+}
+
+// parseAuthorizationRequest validates an authorization request. Until the
+// client and redirect URI are known to be good, errors are returned with an
+// empty request: they can't be sent back to the client, only shown to the
+// user, who may have followed a malicious link.
+func (apiCfg *apiConfig) parseAuthorizationRequest(ctx context.Context, query url.Values) (authorizationRequest, error) {
+	clientID, err := uuid.Parse(query.Get("client_id"))
+	if err != nil {
+		return authorizationRequest{}, errUnknownOAuthClient
+	}
+	client, err := apiCfg.db.GetOAuthClient(ctx, clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return authorizationRequest{}, errUnknownOAuthClient
+	}
+	if err != nil {
+		return authorizationRequest{}, err
+	}
+	redirectURI := query.Get("redirect_uri")
+	if !slices.Contains(client.RedirectUris, redirectURI) {
+		return authorizationRequest{}, errUnregisteredRedirect
+	}
+
+	req := authorizationRequest{
+		Client:        client,
+		RedirectURI:   redirectURI,
+		State:         query.Get("state"),
+		CodeChallenge: query.Get("code_challenge"),
+	}
+	if query.Get("response_type") != "code" {
+		return req, newOAuthError("unsupported_response_type", "response_type must be code")
+	}
+	req.Scopes, err = auth.ParseScopes(strings.Fields(query.Get("scope")))
+	if err != nil {
+		return req, newOAuthError("invalid_scope", err.Error())
+	}
+	if len(req.Scopes) == 0 {
+		return req, newOAuthError("invalid_scope", "At least one scope is required")
+	}
+	if req.CodeChallenge == "" || query.Get("code_challenge_method") != "S256" {
+		return req, newOAuthError("invalid_request", "PKCE is required, with code_challenge_method S256")
+	}
+	return req, nil
+}
+
+// redirectTo is where the user is sent back to the client, with params
+// added to the redirect URI's query.
+func (req authorizationRequest) redirectTo(params url.Values) string {
+	u, _ := url.Parse(req.RedirectURI)
+	query := u.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// respondAuthorizationError rejects an authorization request. Once the
+// redirect URI is trusted, the response tells the frontend where to send
+// the user so the client hears about it too.
+func respondAuthorizationError(w http.ResponseWriter, req authorizationRequest, err error) {
+	if errors.Is(err, errUnknownOAuthClient) || errors.Is(err, errUnregisteredRedirect) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var oerr *oauthError
+	if !errors.As(err, &oerr) {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusBadRequest, struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+		RedirectTo  string `json:"redirect_to"`
+	}{
+		Error:       oerr.Code,
+		Description: oerr.Description,
+		RedirectTo: req.redirectTo(url.Values{
+			"error":             {oerr.Code},
+			"error_description": {oerr.Description},
+		}),
+	})
+}
+
+// consentCovers reports whether the user already approved every scope the
+// client is asking for.
+func consentCovers(consent database.OauthConsent, scopes []auth.Scope) bool {
+	for _, scope := range scopes {
+		if !slices.Contains(consent.Scopes, string(scope)) {
+			return false
+		}
+	}
+	return true
+}
+
+func scopeNames(scopes []auth.Scope) []string {
+	names := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		names = append(names, string(scope))
+	}
+	return names
+}
+
+// authenticateOAuthClient identifies the client calling the token,
+// introspection or revocation endpoint, with HTTP Basic auth or the
+// client_id and client_secret form fields. Public clients only send their
+// client_id. The request form must already be parsed.
+func (apiCfg *apiConfig) authenticateOAuthClient(r *http.Request) (database.OauthClient, error) {
+	rawID, secret, basic := r.BasicAuth()
+	if basic {
+		// Basic credentials are form encoded first (RFC 6749 section 2.3.1).
+		var err error
+		if rawID, err = url.QueryUnescape(rawID); err != nil {
+			return database.OauthClient{}, errInvalidOAuthClient
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return database.OauthClient{}, errInvalidOAuthClient
+		}
+	} else {
+		rawID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	clientID, err := uuid.Parse(rawID)
+	if err != nil {
+		return database.OauthClient{}, errInvalidOAuthClient
+	}
+	client, err := apiCfg.db.GetOAuthClient(r.Context(), clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.OauthClient{}, errInvalidOAuthClient
+	}
+	if err != nil {
+		return database.OauthClient{}, err
+	}
+
+	if !client.SecretHash.Valid {
+		if secret != "" {
+			return database.OauthClient{}, errInvalidOAuthClient
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashRefreshToken(secret)), []byte(client.SecretHash.String)) != 1 {
+		return database.OauthClient{}, errInvalidOAuthClient
+	}
+	return client, nil
+}
+
+// oauthTokenResponse is a successful token endpoint response (RFC 6749
+// section 5.1).
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// issueOAuthTokens issues an access token for grant, and a refresh token
+// that rotates within it. The access token's sid is the grant, so revoking
+// the grant invalidates it through checkOAuthGrant.
+func (apiCfg *apiConfig) issueOAuthTokens(ctx context.Context, qtx *database.Queries, grant database.OauthGrant, user database.User) (oauthTokenResponse, error) {
+	scopes, err := auth.ParseScopes(grant.Scopes)
+	if err != nil {
+		return oauthTokenResponse{}, fmt.Errorf("OAuth grant %s has invalid scopes: %w", grant.ID, err)
+	}
+	accessToken, err := apiCfg.jwtKeys.MakeJWT(auth.TokenSubject{
+		UserID:       user.ID,
+		Role:         auth.RoleUser,
+		SessionID:    grant.ID,
+		TokenVersion: user.TokenVersion,
+		Scope:        auth.JoinScopes(scopes),
+		ClientID:     grant.ClientID.String(),
+	}, oauthAccessTokenTTL)
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+	err = qtx.AddOAuthRefreshToken(ctx, database.AddOAuthRefreshTokenParams{
+		TokenHash: auth.HashRefreshToken(refreshToken),
+		GrantID:   grant.ID,
+	})
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+
+	return oauthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        auth.JoinScopes(scopes),
+	}, nil
+}
+
+// redeemAuthorizationCode trades an authorization code for a new grant. A
+// code redeemed twice was most likely intercepted, so the grant issued the
+// first time is revoked; that failure is returned through failure so the
+// revocation still commits.
+func (apiCfg *apiConfig) redeemAuthorizationCode(r *http.Request, client database.OauthClient) (response oauthTokenResponse, failure error, err error) {
+	err = apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		code, err := qtx.GetOAuthAuthorizationCodeForUpdate(r.Context(), auth.HashRefreshToken(r.PostForm.Get("code")))
+		if errors.Is(err, sql.ErrNoRows) {
+			failure = errInvalidOAuthGrant
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if code.ClientID != client.ID {
+			failure = errInvalidOAuthGrant
+			return nil
+		}
+		if code.GrantID.Valid {
+			failure = errInvalidOAuthGrant
+			return qtx.RevokeOAuthGrant(r.Context(), code.GrantID.UUID)
+		}
+		if code.ExpiresAt.Before(time.Now()) || code.RedirectUri != r.PostForm.Get("redirect_uri") {
+			failure = errInvalidOAuthGrant
+			return nil
+		}
+		if !auth.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+			failure = newOAuthError("invalid_grant", "code_verifier doesn't match the code challenge")
+			return nil
+		}
+
+		user, err := qtx.GetUser(r.Context(), code.UserID)
+		if err != nil {
+			return err
+		}
+		if user.SuspendedAt.Valid {
+			failure = newOAuthError("invalid_grant", errUserSuspended.Error())
+			return nil
+		}
+
+		grant, err := qtx.CreateOAuthGrant(r.Context(), database.CreateOAuthGrantParams{
+			ClientID: client.ID,
+			UserID:   user.ID,
+			Scopes:   code.Scopes,
+		})
+		if err != nil {
+			return err
+		}
+		err = qtx.RedeemOAuthAuthorizationCode(r.Context(), database.RedeemOAuthAuthorizationCodeParams{
+			CodeHash: code.CodeHash,
+			GrantID:  uuid.NullUUID{UUID: grant.ID, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		response, err = apiCfg.issueOAuthTokens(r.Context(), qtx, grant, user)
+		return err
+	})
+	return response, failure, err
+}
+
+// refreshOAuthGrant rotates an OAuth refresh token. As with sessions, a
+// refresh token used twice revokes everything issued from the same grant.
+func (apiCfg *apiConfig) refreshOAuthGrant(r *http.Request, client database.OauthClient) (response oauthTokenResponse, failure error, err error) {
+	err = apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		token, err := qtx.GetOAuthRefreshTokenForUpdate(r.Context(), auth.HashRefreshToken(r.PostForm.Get("refresh_token")))
+		if errors.Is(err, sql.ErrNoRows) {
+			failure = errInvalidOAuthGrant
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		grant, err := qtx.GetOAuthGrant(r.Context(), token.GrantID)
+		if err != nil {
+			return err
+		}
+		if grant.ClientID != client.ID {
+			failure = errInvalidOAuthGrant
+			return nil
+		}
+		if token.RevokedAt.Valid {
+			failure = errInvalidOAuthGrant
+			return qtx.RevokeOAuthGrant(r.Context(), grant.ID)
+		}
+		if grant.RevokedAt.Valid || token.ExpiresAt.Before(time.Now()) {
+			failure = errInvalidOAuthGrant
+			return nil
+		}
+
+		user, err := qtx.GetUser(r.Context(), grant.UserID)
+		if err != nil {
+			return err
+		}
+		if user.SuspendedAt.Valid {
+			failure = newOAuthError("invalid_grant", errUserSuspended.Error())
+			return nil
+		}
+
+		if err := qtx.RevokeOAuthRefreshToken(r.Context(), token.TokenHash); err != nil {
+			return err
+		}
+		response, err = apiCfg.issueOAuthTokens(r.Context(), qtx, grant, user)
+		return err
+	})
+	return response, failure, err
+}
+
+// checkOAuthGrant rejects access tokens issued to an OAuth client once the
+// user has revoked the client's access. Other tokens pass.
+func (apiCfg *apiConfig) checkOAuthGrant(ctx context.Context) auth.ClaimCheck {
+	return func(claims auth.Claims) error {
+		if claims.ClientID == "" {
+			return nil
+		}
+		grant, err := apiCfg.db.GetOAuthGrant(ctx, claims.SessionID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return auth.ErrTokenRevoked
+		}
+		if err != nil {
+			return err
+		}
+		if grant.RevokedAt.Valid || grant.ClientID.String() != claims.ClientID {
+			return auth.ErrTokenRevoked
+		}
+		return nil
+	}
+}
+
+// tokenIntrospection describes a token to the client it was issued to
+// (RFC 7662). Inactive tokens are only {"active": false}.
+type tokenIntrospection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// introspectOAuthToken looks token up as an access token, then as a refresh
+// token. Tokens issued to other clients are reported as inactive, so
+// clients can't probe each other's tokens.
+func (apiCfg *apiConfig) introspectOAuthToken(ctx context.Context, client database.OauthClient, token string) (tokenIntrospection, error) {
+	claims, err := apiCfg.jwtKeys.ParseJWT(token, apiCfg.checkTokenVersion(ctx), apiCfg.checkOAuthGrant(ctx))
+	if err == nil {
+		if claims.ClientID != client.ID.String() {
+			return tokenIntrospection{}, nil
+		}
+		introspection := tokenIntrospection{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			Subject:   claims.Subject,
+			TokenType: "access_token",
+			ExpiresAt: claims.ExpiresAt.Unix(),
+		}
+		if claims.IssuedAt != nil {
+			introspection.IssuedAt = claims.IssuedAt.Unix()
+		}
+		return introspection, nil
+	}
+
+	refreshToken, err := apiCfg.db.GetOAuthRefreshToken(ctx, auth.HashRefreshToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return tokenIntrospection{}, nil
+	}
+	if err != nil {
+		return tokenIntrospection{}, err
+	}
+	grant, err := apiCfg.db.GetOAuthGrant(ctx, refreshToken.GrantID)
+	if err != nil {
+		return tokenIntrospection{}, err
+	}
+	if grant.ClientID != client.ID || grant.RevokedAt.Valid || refreshToken.RevokedAt.Valid || refreshToken.ExpiresAt.Before(time.Now()) {
+		return tokenIntrospection{}, nil
+	}
+	return tokenIntrospection{
+		Active:    true,
+		Scope:     strings.Join(grant.Scopes, " "),
+		ClientID:  grant.ClientID.String(),
+		Subject:   grant.UserID.String(),
+		TokenType: "refresh_token",
+		ExpiresAt: refreshToken.ExpiresAt.Unix(),
+		IssuedAt:  refreshToken.CreatedAt.Unix(),
+	}, nil
+}
+
+// revokeOAuthToken revokes the grant an access or refresh token was issued
+// from, so the client loses everything it got with it. Tokens that don't
+// exist or belong to another client are ignored.
+func (apiCfg *apiConfig) revokeOAuthToken(ctx context.Context, client database.OauthClient, token string) error {
+	refreshToken, err := apiCfg.db.GetOAuthRefreshToken(ctx, auth.HashRefreshToken(token))
+	if err == nil {
+		grant, err := apiCfg.db.GetOAuthGrant(ctx, refreshToken.GrantID)
+		if err != nil {
+			return err
+		}
+		if grant.ClientID != client.ID {
+			return nil
+		}
+		return apiCfg.db.RevokeOAuthGrant(ctx, grant.ID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	// An expired access token has nothing left to revoke, and a revoked
+	// one already had its grant revoked.
+	claims, err := apiCfg.jwtKeys.ParseJWT(token, apiCfg.checkOAuthGrant(ctx))
+	if err != nil || claims.ClientID != client.ID.String() {
+		return nil
+	}
+	return apiCfg.db.RevokeOAuthGrant(ctx, claims.SessionID)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/LoronsoDev/chirpy/internal/auth"
+	"github.com/LoronsoDev/chirpy/internal/database"
+	"github.com/LoronsoDev/chirpy/internal/mail"
+	"github.com/LoronsoDev/chirpy/internal/oidc"
+)
+
+const (
+	oidcLoginTTL    = 10 * time.Minute
+	oidcStateCookie = "chirpy_oidc_state"
+	oidcCookiePath  = "/api/login/oidc"
+)
+
+var (
+	errInvalidOIDCLogin = errors.New("Sign-in attempt is invalid or has expired, please try again")
+	// The provider has to vouch for the address before it's trusted for
+	// anything, linking or creating an account alike.
+	errOIDCEmailNotVerified = errors.New("Your identity provider hasn't verified your email address")
+	// Someone could have signed up with the address before its owner ever
+	// used single sign-on; linking to that account would hand it to them.
+	errOIDCAccountUnverified = errors.New("An account with this email exists but its address isn't verified; verify it before using single sign-on")
+)
+
+// loadOIDCClient sets up single sign-on from the environment. It's off
+// unless OIDC_ISSUER is set, in which case OIDC_CLIENT_ID is required and
+// OIDC_CLIENT_SECRET is for confidential clients. OIDC_REDIRECT_URL
+// defaults to the callback under publicURL, and has to be registered with
+// the provider.
+func loadOIDCClient(ctx context.Context, publicURL string) (*oidc.Client, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if redirectURL == "" {
+		redirectURL = publicURL + "/api/login/oidc/callback"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	return oidc.NewClient(ctx, oidc.Config{
+		Issuer:       issuer,
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  redirectURL,
+	}, &http.Client{Timeout: 10 * time.Second})
+}
+
+// setOIDCStateCookie ties a sign-in to the browser that started it, so an
+// attacker can't get someone signed in to the attacker's account by sending
+// them a callback URL. An empty state clears the cookie.
+func (apiCfg *apiConfig) setOIDCStateCookie(w http.ResponseWriter, state string) {
+	cookie := &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     oidcCookiePath,
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(apiCfg.publicURL, "https://"),
+		// Lax, not Strict: the callback is a top level navigation from the
+		// provider's site.
+		SameSite: http.SameSiteLaxMode,
+	}
+	if state == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// takeOIDCLogin checks the callback's state against the cookie and the
+// stored sign-in, which can only be used once.
+func (apiCfg *apiConfig) takeOIDCLogin(r *http.Request) (database.OidcLogin, error) {
+	state := r.URL.Query().Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if state == "" || err != nil || cookie.Value != state {
+		return database.OidcLogin{}, errInvalidOIDCLogin
+	}
+
+	login, err := apiCfg.db.TakeOIDCLogin(r.Context(), auth.HashRefreshToken(state))
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.OidcLogin{}, errInvalidOIDCLogin
+	}
+	if err != nil {
+		return database.OidcLogin{}, err
+	}
+	if login.ExpiresAt.Before(time.Now()) {
+		return database.OidcLogin{}, errInvalidOIDCLogin
+	}
+	return login, nil
+}
+
+// userForIdentity finds the user linked to the provider account that signed
+// in. Accounts seen for the first time are linked to the user with the same
+// verified email, or get a new user.
+func (apiCfg *apiConfig) userForIdentity(ctx context.Context, issuer string, idToken *oidc.IDToken) (database.User, error) {
+	var user database.User
+	err := apiCfg.withTx(ctx, func(qtx *database.Queries) error {
+		identity, err := qtx.GetUserIdentity(ctx, database.GetUserIdentityParams{
+			Issuer:  issuer,
+			Subject: idToken.Subject,
+		})
+		if err == nil {
+			user, err = qtx.GetUser(ctx, identity.UserID)
+			return err
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		if !idToken.EmailVerified {
+			return errOIDCEmailNotVerified
+		}
+		email, err := mail.NormalizeAddress(idToken.Email)
+		if err != nil {
+			return err
+		}
+		user, err = qtx.GetUserByEmail(ctx, email)
+		if errors.Is(err, sql.ErrNoRows) {
+			user, err = qtx.CreateExternalUser(ctx, email)
+		} else if err == nil && !user.EmailVerified {
+			return errOIDCAccountUnverified
+		}
+		if err != nil {
+			return err
+		}
+
+		return qtx.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+			Issuer:  issuer,
+			Subject: idToken.Subject,
+			UserID:  user.ID,
+			Email:   email,
+		})
+	})
+	return user, err
+}
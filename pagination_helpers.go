@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/LoronsoDev/chirpy/internal/cursor"
+	"github.com/LoronsoDev/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// pageParams holds the keyset position and size requested by the client.
+type pageParams struct {
+	Limit int32
+	After *cursor.Cursor
+}
+
+func (apiCfg *apiConfig) parsePageParams(r *http.Request) (pageParams, error) {
+	page := pageParams{Limit: defaultPageLimit}
+
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return pageParams{}, errors.New("limit must be a number between 1 and " + strconv.Itoa(maxPageLimit))
+		}
+		page.Limit = int32(limit)
+	}
+
+	if token := r.URL.Query().Get("cursor"); token != "" {
+		after, err := cursor.Decode(token, apiCfg.cursorSecret)
+		if err != nil {
+			return pageParams{}, err
+		}
+		page.After = &after
+	}
+
+	return page, nil
+}
+
+// cursorCreatedAt and cursorID translate the page position into nullable
+// query arguments; a nil position means "start from the beginning".
+func (page pageParams) cursorCreatedAt() sql.NullTime {
+	if page.After == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: page.After.CreatedAt, Valid: true}
+}
+
+func (page pageParams) cursorID() uuid.NullUUID {
+	if page.After == nil {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: page.After.ID, Valid: true}
+}
+
+// setNextPageLink signs a cursor for the last returned row and advertises it
+// through a Link header, keeping every other query parameter untouched.
+func (apiCfg *apiConfig) setNextPageLink(w http.ResponseWriter, r *http.Request, last cursor.Cursor) string {
+	next := cursor.Encode(last, apiCfg.cursorSecret)
+
+	query := r.URL.Query()
+	query.Set("cursor", next)
+	w.Header().Set("Link", "<"+r.URL.Path+"?"+query.Encode()+">; rel=\"next\"")
+
+	return next
+}
+
+type chirpPage struct {
+	Chirps     []Chirp `json:"chirps"`
+	NextCursor *string `json:"next_cursor"`
+}
+
+// newChirpPage trims the extra row fetched to detect a following page and,
+// if there is one, links to it.
+func (apiCfg *apiConfig) newChirpPage(w http.ResponseWriter, r *http.Request, dbChirps []database.Chirp, page pageParams, viewerID uuid.NullUUID) (chirpPage, error) {
+	res := chirpPage{}
+	if len(dbChirps) > int(page.Limit) {
+		dbChirps = dbChirps[:page.Limit]
+		last := dbChirps[len(dbChirps)-1]
+		next := apiCfg.setNextPageLink(w, r, cursor.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+		res.NextCursor = &next
+	}
+
+	chirps, err := apiCfg.chirpResponses(r.Context(), dbChirps, viewerID)
+	if err != nil {
+		return chirpPage{}, err
+	}
+	res.Chirps = chirps
+	return res, nil
+}
+
+type followPage struct {
+	Users      []followUser `json:"users"`
+	NextCursor *string      `json:"next_cursor"`
+}
+
+// newFollowPage trims the extra row fetched to detect a following page and,
+// if there is one, links to it. Users are paged by when the follow happened.
+func (apiCfg *apiConfig) newFollowPage(w http.ResponseWriter, r *http.Request, users []followUser, page pageParams) followPage {
+	res := followPage{Users: users}
+	if len(users) > int(page.Limit) {
+		res.Users = users[:page.Limit]
+		last := res.Users[len(res.Users)-1]
+		next := apiCfg.setNextPageLink(w, r, cursor.Cursor{CreatedAt: last.FollowedAt, ID: last.ID})
+		res.NextCursor = &next
+	}
+	return res
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/LoronsoDev/chirpy/internal/database"
+	"github.com/LoronsoDev/chirpy/internal/mail"
+)
+
+// passwordResetTTL is how long a reset link works.
+const passwordResetTTL = time.Hour
+
+var errInvalidResetToken = errors.New("Reset token is invalid or has expired")
+
+// sendPasswordReset emails a reset link.
+func (apiCfg *apiConfig) sendPasswordReset(user database.User, token string) {
+	link := fmt.Sprintf("%s/reset-password?token=%s", apiCfg.publicURL, url.QueryEscape(token))
+	apiCfg.sendInBackground(user.ID, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your Chirpy account.\n\n"+
+			"Follow this link within %s to choose a new one:\n%s\n\n"+
+			"If it wasn't you, you can ignore this email.", passwordResetTTL, link),
+	})
+}
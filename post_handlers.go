@@ -1,22 +1,60 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/LoronsoDev/chirpy/internal/auth"
 	"github.com/LoronsoDev/chirpy/internal/database"
+	"github.com/LoronsoDev/chirpy/internal/mail"
 	"github.com/google/uuid"
 )
 
 type Chirp struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserID    uuid.UUID `json:"user_id"`
+	ID              uuid.UUID  `json:"id"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	Body            string     `json:"body"`
+	UserID          uuid.UUID  `json:"user_id"`
+	Deleted         bool       `json:"deleted"`
+	Hidden          bool       `json:"hidden"`
+	ReplyTo         *uuid.UUID `json:"reply_to"`
+	ThreadID        uuid.UUID  `json:"thread_id"`
+	RechirpOf       *uuid.UUID `json:"rechirp_of"`
+	QuoteOf         *uuid.UUID `json:"quote_of"`
+	ReferencedChirp *Chirp     `json:"referenced_chirp,omitempty"`
+	LikeCount       int64      `json:"like_count"`
+	LikedByMe       bool       `json:"liked_by_me"`
+}
+
+func newChirpResponse(dbChirp database.Chirp) Chirp {
+	chirp := Chirp{
+		ID:        dbChirp.ID,
+		CreatedAt: dbChirp.CreatedAt,
+		UpdatedAt: dbChirp.UpdatedAt,
+		Body:      dbChirp.Body,
+		UserID:    dbChirp.UserID,
+		Deleted:   dbChirp.DeletedAt.Valid,
+		Hidden:    dbChirp.HiddenAt.Valid,
+		ThreadID:  dbChirp.ThreadID,
+	}
+	if dbChirp.ReplyTo.Valid {
+		chirp.ReplyTo = &dbChirp.ReplyTo.UUID
+	}
+	if dbChirp.RechirpOf.Valid {
+		chirp.RechirpOf = &dbChirp.RechirpOf.UUID
+	}
+	if dbChirp.QuoteOf.Valid {
+		chirp.QuoteOf = &dbChirp.QuoteOf.UUID
+	}
+	return chirp
 }
 
 // func handlerHealth(res http.ResponseWriter, req *http.Request) {
@@ -57,56 +95,84 @@ func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
 }
 
 func (apiCfg apiConfig) handlerNewChirp(w http.ResponseWriter, r *http.Request) {
-	const maxChars = 140
 	type incomingParams struct {
-		Body   string    `json:"body"`
-		UserID uuid.UUID `json:"user_id"`
+		Body    string     `json:"body"`
+		UserID  uuid.UUID  `json:"user_id"`
+		ReplyTo *uuid.UUID `json:"reply_to"`
+		QuoteOf *uuid.UUID `json:"quote_of"`
 	}
 	decoder := json.NewDecoder(r.Body)
 	incParams := incomingParams{}
 	err := decoder.Decode(&incParams)
 
-	token, err := auth.GetBearerToken(r.Header)
+	defer r.Body.Close()
 
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Provided JWT token can't be retrieved correctly")
+		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, apiCfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, err.Error())
+	userID := userIDFromContext(r.Context())
+	if err := apiCfg.checkCanPost(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusForbidden, err.Error())
 		return
 	}
 
-	defer r.Body.Close()
-
+	verdict, err := apiCfg.cleanChirpBody(incParams.Body)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters")
-		return
-	}
-
-	if len(incParams.Body) > maxChars {
-		respondWithError(w, 400, "Chirp is too long")
+		respondWithChirpBodyError(w, err)
 		return
 	}
 	addChirpParams := database.AddChirpParams{}
-	addChirpParams.Body = getCleanBody(incParams.Body)
+	addChirpParams.Body = verdict.Body
 	addChirpParams.UserID = userID
 
-	newChirp, err := apiCfg.db.AddChirp(r.Context(), addChirpParams)
+	replyToAuthor := uuid.NullUUID{}
+	if incParams.ReplyTo != nil {
+		parent, err := apiCfg.getOriginalChirp(r.Context(), *incParams.ReplyTo, uuid.NullUUID{UUID: userID, Valid: true})
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "The chirp you are replying to doesn't exist")
+			return
+		}
+		addChirpParams.ReplyTo = uuid.NullUUID{UUID: parent.ID, Valid: true}
+		addChirpParams.ThreadID = uuid.NullUUID{UUID: parent.ThreadID, Valid: true}
+		replyToAuthor = uuid.NullUUID{UUID: parent.UserID, Valid: true}
+	}
+
+	if incParams.QuoteOf != nil {
+		quoted, err := apiCfg.getOriginalChirp(r.Context(), *incParams.QuoteOf, uuid.NullUUID{UUID: userID, Valid: true})
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "The chirp you are quoting doesn't exist")
+			return
+		}
+		addChirpParams.QuoteOf = uuid.NullUUID{UUID: quoted.ID, Valid: true}
+	}
+
+	var newChirp database.Chirp
+	err = apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		newChirp, err = qtx.AddChirp(r.Context(), addChirpParams)
+		if err != nil {
+			return err
+		}
+		if err := indexChirpTags(r.Context(), qtx, newChirp); err != nil {
+			return err
+		}
+		if err := flagChirp(r.Context(), qtx, newChirp.ID, verdict); err != nil {
+			return err
+		}
+		return notifyNewChirp(r.Context(), qtx, newChirp, replyToAuthor)
+	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp, err: "+err.Error())
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, Chirp{
-		ID:        newChirp.ID,
-		CreatedAt: newChirp.CreatedAt,
-		UpdatedAt: newChirp.UpdatedAt,
-		Body:      newChirp.Body,
-		UserID:    newChirp.UserID,
-	})
+	chirp, err := apiCfg.chirpResponse(r.Context(), newChirp, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, chirp)
 }
 
 func (apiCfg *apiConfig) handlerNewUser(w http.ResponseWriter, r *http.Request) {
@@ -125,73 +191,139 @@ func (apiCfg *apiConfig) handlerNewUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	email, err := mail.NormalizeAddress(incParams.Email)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	hashed_password, err := auth.HashPassword(incParams.Password)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
 
 	createUserParams := database.CreateUserParams{
-		Email:          incParams.Email,
+		Email:          email,
 		HashedPassword: hashed_password,
 	}
 
-	newUser, err := apiCfg.db.CreateUser(r.Context(), createUserParams)
-
+	var newUser database.User
+	var verificationToken string
+	err = apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		newUser, err = qtx.CreateUser(r.Context(), createUserParams)
+		if err != nil {
+			return err
+		}
+		verificationToken, err = createEmailVerification(r.Context(), qtx, newUser)
+		return err
+	})
 	if err != nil {
 		respondWithError(w, 400, err.Error())
+		return
 	}
+	apiCfg.sendEmailVerification(newUser, verificationToken)
 
 	respondWithJSON(w, http.StatusCreated, struct {
-		ID        uuid.UUID `json:"id"`
-		CreatedAt time.Time `json:"created_at"`
-		UpdatedAt time.Time `json:"updated_at"`
-		Email     string    `json:"email"`
-		ChirpyRed bool      `json:"is_chirpy_red"`
+		ID            uuid.UUID `json:"id"`
+		CreatedAt     time.Time `json:"created_at"`
+		UpdatedAt     time.Time `json:"updated_at"`
+		Email         string    `json:"email"`
+		EmailVerified bool      `json:"email_verified"`
+		ChirpyRed     bool      `json:"is_chirpy_red"`
 	}{
-		ID:        newUser.ID,
-		CreatedAt: newUser.CreatedAt,
-		UpdatedAt: newUser.UpdatedAt,
-		Email:     newUser.Email,
-		ChirpyRed: newUser.ChirpyRed,
+		ID:            newUser.ID,
+		CreatedAt:     newUser.CreatedAt,
+		UpdatedAt:     newUser.UpdatedAt,
+		Email:         newUser.Email,
+		EmailVerified: newUser.EmailVerified,
+		ChirpyRed:     newUser.ChirpyRed,
 	})
 }
 
+var errRefreshTokenExpired = errors.New("refresh token has expired")
+
+// handlerRefresh trades a refresh token for a new access token and a new
+// refresh token, revoking the one presented. A token that was already
+// rotated or revoked being used again means it leaked, so every token from
+// the same login is revoked along with it.
 func (apiCfg apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, http.StatusUnauthorized, err.Error())
 		return
 	}
-	tokenData, err := apiCfg.db.GetTokenInfo(r.Context(), refreshToken)
+	newRefreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, err.Error())
+		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	if tokenData.RevokedAt.Valid || tokenData.ExpiresAt.Time.Before(time.Now()) {
+
+	var user database.User
+	var sessionID uuid.UUID
+	reused := false
+	err = apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		tokenData, err := qtx.GetTokenInfoForUpdate(r.Context(), auth.HashRefreshToken(refreshToken))
+		if err != nil {
+			return err
+		}
+		if tokenData.RevokedAt.Valid {
+			reused = true
+			return qtx.RevokeTokenFamily(r.Context(), tokenData.FamilyID)
+		}
+		if tokenData.ExpiresAt.Time.Before(time.Now()) {
+			return errRefreshTokenExpired
+		}
+
+		user, err = qtx.GetUser(r.Context(), tokenData.UserID)
+		if err != nil {
+			return err
+		}
+		sessionID = tokenData.FamilyID
+		if err := qtx.RevokeToken(r.Context(), tokenData.TokenHash); err != nil {
+			return err
+		}
+		return qtx.AddRefreshToken(r.Context(), database.AddRefreshTokenParams{
+			TokenHash: auth.HashRefreshToken(newRefreshToken),
+			UserID:    tokenData.UserID,
+			FamilyID:  tokenData.FamilyID,
+			UserAgent: r.UserAgent(),
+			IpAddress: clientIP(r),
+		})
+	})
+	if reused || errors.Is(err, sql.ErrNoRows) || errors.Is(err, errRefreshTokenExpired) {
 		respondWithError(w, http.StatusUnauthorized, "Token is revoked or has expired")
 		return
 	}
-	token, _ := auth.MakeRefreshToken()
-	rtParams := database.AddRefreshTokenParams{Token: token, UserID: tokenData.UserID}
-	apiCfg.db.AddRefreshToken(r.Context(), rtParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 
-	accessToken, err := auth.MakeJWT(tokenData.UserID, apiCfg.jwtSecret, time.Hour)
+	accessToken, err := apiCfg.makeAccessToken(user, sessionID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
 	respondWithJSON(w, http.StatusOK, struct {
-		Token string `json:"token"`
-	}{Token: accessToken})
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}{
+		Token:        accessToken,
+		RefreshToken: newRefreshToken,
+	})
 }
 
 func (apiCfg apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
 	}
-	err = apiCfg.db.RevokeToken(r.Context(), refreshToken)
+	err = apiCfg.db.RevokeToken(r.Context(), auth.HashRefreshToken(refreshToken))
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
 	respondWithJSON(w, http.StatusNoContent, struct{}{})
 }
@@ -203,88 +335,1111 @@ func (apiCfg apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	}
 	decoder := json.NewDecoder(r.Body)
 	incParams := incomingParams{}
-	err := decoder.Decode(&incParams)
+	if err := decoder.Decode(&incParams); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	defer r.Body.Close()
+
+	// Not NormalizeAddress: accounts made before addresses were checked
+	// still have to be able to log in.
+	email := mail.CanonicalAddress(incParams.Email)
+
+	// Throttling goes by the email, not the user, so it looks the same
+	// whether or not the account exists.
+	ip := clientIP(r)
+	wait, err := apiCfg.reserveLoginAttempt(r.Context(), email, ip)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if wait > 0 {
+		respondTooManyLoginAttempts(w, wait)
+		return
+	}
+
+	userStoredData, err := apiCfg.db.GetUserByEmail(r.Context(), email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !passwordMatches(userStoredData, err == nil, incParams.Password) {
+		// Already counted by reserveLoginAttempt.
+		respondWithError(w, http.StatusUnauthorized, errInvalidCredentials.Error())
+		return
+	}
+	if err := apiCfg.releaseLoginAttempt(r.Context(), email, ip); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	//At this point, user is the same and password has been guessed...
+	if userStoredData.SuspendedAt.Valid {
+		respondWithError(w, http.StatusForbidden, errUserSuspended.Error())
+		return
+	}
+
+	// With two-factor authentication on, the password only gets the user a
+	// challenge to answer at /api/login/mfa.
+	totpEnabled, err := apiCfg.totpEnabled(r.Context(), userStoredData.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if totpEnabled {
+		apiCfg.respondWithMFAChallenge(w, r, userStoredData)
+		return
+	}
+
+	apiCfg.respondWithNewSession(w, r, userStoredData)
+}
+
+// handlerForgotPassword emails a reset link to the account with the given
+// email. It answers the same whether or not the account exists, so it can't
+// be used to find out who is registered.
+func (apiCfg *apiConfig) handlerForgotPassword(w http.ResponseWriter, r *http.Request) {
+	type incomingParams struct {
+		Email string `json:"email"`
+	}
+	incParams := incomingParams{}
+	if err := json.NewDecoder(r.Body).Decode(&incParams); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	defer r.Body.Close()
+
+	user, err := apiCfg.db.GetUserByEmail(r.Context(), mail.CanonicalAddress(incParams.Email))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithJSON(w, http.StatusAccepted, struct{}{})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Reset tokens are random like refresh tokens, so they're stored the
+	// same way.
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	err = apiCfg.db.CreatePasswordReset(r.Context(), database.CreatePasswordResetParams{
+		TokenHash: auth.HashRefreshToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(passwordResetTTL),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	apiCfg.sendPasswordReset(user, token)
+	respondWithJSON(w, http.StatusAccepted, struct{}{})
+}
 
+// handlerResetPassword sets a new password with a token from
+// handlerForgotPassword. Every session of the user is logged out, since
+// whoever knew the old password may still hold one.
+func (apiCfg *apiConfig) handlerResetPassword(w http.ResponseWriter, r *http.Request) {
+	type incomingParams struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	incParams := incomingParams{}
+	if err := json.NewDecoder(r.Body).Decode(&incParams); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
 	defer r.Body.Close()
 
-	userStoredData, err := apiCfg.db.GetUserByEmail(r.Context(), incParams.Email)
+	if incParams.Token == "" || incParams.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Token and password are required")
+		return
+	}
 
+	hashedPassword, err := auth.HashPassword(incParams.Password)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	err = auth.CheckPasswordHash(incParams.Password, userStoredData.HashedPassword)
+	err = apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		reset, err := qtx.GetPasswordResetForUpdate(r.Context(), auth.HashRefreshToken(incParams.Token))
+		if errors.Is(err, sql.ErrNoRows) {
+			return errInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+		if reset.UsedAt.Valid || reset.ExpiresAt.Before(time.Now()) {
+			return errInvalidResetToken
+		}
+
+		err = qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+			ID:             reset.UserID,
+			HashedPassword: hashedPassword,
+		})
+		if err != nil {
+			return err
+		}
+		if err := qtx.UsePasswordResets(r.Context(), reset.UserID); err != nil {
+			return err
+		}
+		if err := qtx.RevokeUserRefreshTokens(r.Context(), reset.UserID); err != nil {
+			return err
+		}
+		if err := qtx.RevokeUserOAuthGrants(r.Context(), reset.UserID); err != nil {
+			return err
+		}
+		// Whoever reset the password owns the mailbox, so failed guesses
+		// shouldn't keep them out.
+		user, err := qtx.GetUser(r.Context(), reset.UserID)
+		if err != nil {
+			return err
+		}
+		if _, err := qtx.ClearLoginThrottle(r.Context(), accountThrottleKey(user.Email)); err != nil {
+			return err
+		}
+		return qtx.IncrementTokenVersion(r.Context(), reset.UserID)
+	})
+	if errors.Is(err, errInvalidResetToken) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, err.Error())
+		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	//At this point, user is the same and password has been guessed...
 
-	token, err := auth.MakeJWT(userStoredData.ID, apiCfg.jwtSecret, time.Duration(time.Hour))
+	respondWithJSON(w, http.StatusNoContent, struct{}{})
+}
+
+// handlerResendVerification emails a new verification link, for users whose
+// link expired or got lost.
+func (apiCfg *apiConfig) handlerResendVerification(w http.ResponseWriter, r *http.Request) {
+	user, err := apiCfg.db.GetUser(r.Context(), userIDFromContext(r.Context()))
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, err.Error())
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if user.EmailVerified {
+		respondWithError(w, http.StatusConflict, errEmailAlreadyVerified.Error())
+		return
+	}
+
+	if err := apiCfg.startEmailVerification(r.Context(), user); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, struct{}{})
+}
+
+// handlerLoginMFA finishes a login started with a password by checking the
+// second factor: a code from the user's authenticator app, or one of their
+// recovery codes.
+func (apiCfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type incomingParams struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	incParams := incomingParams{}
+	if err := json.NewDecoder(r.Body).Decode(&incParams); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	defer r.Body.Close()
+
+	if incParams.MFAToken == "" || (incParams.Code == "" && incParams.RecoveryCode == "") {
+		respondWithError(w, http.StatusBadRequest, "mfa_token and either code or recovery_code are required")
+		return
+	}
+
+	// A wrong code has to be counted against the challenge and the user,
+	// so it's reported through failure and the transaction still commits.
+	var user database.User
+	var failure error
+	var wait time.Duration
+	err := apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		challenge, err := qtx.GetMFAChallengeForUpdate(r.Context(), auth.HashRefreshToken(incParams.MFAToken))
+		if errors.Is(err, sql.ErrNoRows) {
+			failure = errInvalidMFAChallenge
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if challenge.ExpiresAt.Before(time.Now()) || challenge.Attempts >= maxMFAAttempts {
+			failure = errInvalidMFAChallenge
+			return qtx.DeleteMFAChallenge(r.Context(), challenge.TokenHash)
+		}
+
+		wait, err = verifySecondFactor(r.Context(), qtx, challenge.UserID, incParams.Code, incParams.RecoveryCode)
+		if wait > 0 {
+			return nil
+		}
+		if errors.Is(err, errInvalidMFACode) {
+			failure = errInvalidMFACode
+			return qtx.IncrementMFAChallengeAttempts(r.Context(), challenge.TokenHash)
+		}
+		if errors.Is(err, errTOTPNotEnabled) {
+			// Turned off since the challenge was issued.
+			failure = errInvalidMFAChallenge
+			return qtx.DeleteMFAChallenge(r.Context(), challenge.TokenHash)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := qtx.DeleteMFAChallenge(r.Context(), challenge.TokenHash); err != nil {
+			return err
+		}
+		user, err = qtx.GetUser(r.Context(), challenge.UserID)
+		return err
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if wait > 0 {
+		respondTooManyLoginAttempts(w, wait)
+		return
+	}
+	if failure != nil {
+		respondWithError(w, http.StatusUnauthorized, failure.Error())
+		return
+	}
+	if user.SuspendedAt.Valid {
+		respondWithError(w, http.StatusForbidden, errUserSuspended.Error())
+		return
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
+	apiCfg.respondWithNewSession(w, r, user)
+}
+
+// handlerEnrollTOTP starts turning on two-factor authentication. The secret
+// isn't used for logins until handlerConfirmTOTP sees a code made with it.
+func (apiCfg *apiConfig) handlerEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := apiCfg.db.GetUser(r.Context(), userIDFromContext(r.Context()))
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, err.Error())
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
 
-	rtParams := database.AddRefreshTokenParams{
-		Token:  refreshToken,
-		UserID: userStoredData.ID,
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	rows, err := apiCfg.db.StartTOTPEnrollment(r.Context(), database.StartTOTPEnrollmentParams{
+		UserID: user.ID,
+		Secret: secret,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusConflict, errTOTPAlreadyEnabled.Error())
+		return
 	}
-	apiCfg.db.AddRefreshToken(r.Context(), rtParams)
 
 	respondWithJSON(w, http.StatusOK, struct {
-		ID           uuid.UUID `json:"id"`
-		CreatedAt    time.Time `json:"created_at"`
-		UpdatedAt    time.Time `json:"updated_at"`
-		Email        string    `json:"email"`
-		Token        string    `json:"token"`
-		RefreshToken string    `json:"refresh_token"`
-		ChirpyRed    bool      `json:"is_chirpy_red"`
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
 	}{
-		ID:           userStoredData.ID,
-		CreatedAt:    userStoredData.CreatedAt,
-		UpdatedAt:    userStoredData.UpdatedAt,
-		Email:        userStoredData.Email,
-		Token:        token,
-		RefreshToken: refreshToken,
-		ChirpyRed:    userStoredData.ChirpyRed,
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(secret, totpIssuer, user.Email),
 	})
 }
 
-func (apiCfg apiConfig) handlerPolkaWebhook(w http.ResponseWriter, r *http.Request) {
+// handlerConfirmTOTP turns on two-factor authentication once the user shows
+// their app generates the right codes, and hands out their recovery codes.
+func (apiCfg *apiConfig) handlerConfirmTOTP(w http.ResponseWriter, r *http.Request) {
 	type incomingParams struct {
-		Event string `json:"event"`
-		Data  struct {
-			UserID uuid.UUID `json:"user_id"`
-		} `json:"data"`
+		Code string `json:"code"`
 	}
-	decoder := json.NewDecoder(r.Body)
 	incParams := incomingParams{}
-	err := decoder.Decode(&incParams)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, err.Error())
-		return
-	}
-	apiKey, err := auth.GetAPIKey(r.Header)
-	validKey := apiCfg.polkaKey == apiKey
-	if err != nil || !validKey {
-		respondWithError(w, http.StatusUnauthorized, "")
+	if err := json.NewDecoder(r.Body).Decode(&incParams); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
 		return
 	}
 	defer r.Body.Close()
 
-	if incParams.Event == "user.upgraded" {
-		err = apiCfg.db.UpgradeUser(r.Context(), incParams.Data.UserID)
+	userID := userIDFromContext(r.Context())
+	var recoveryCodes []string
+	err := apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		totp, err := qtx.GetUserTOTPForUpdate(r.Context(), userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errTOTPNotEnrolled
+		}
 		if err != nil {
-			respondWithError(w, http.StatusNotFound, err.Error())
-			return
+			return err
 		}
-		respondWithJSON(w, http.StatusNoContent, struct{}{})
+		if totp.ConfirmedAt.Valid {
+			return errTOTPAlreadyEnabled
+		}
+
+		step, ok := auth.ValidateTOTP(totp.Secret, incParams.Code, time.Now(), totp.LastUsedStep)
+		if !ok {
+			return errInvalidMFACode
+		}
+		err = qtx.ConfirmTOTP(r.Context(), database.ConfirmTOTPParams{
+			UserID:       userID,
+			LastUsedStep: step,
+		})
+		if err != nil {
+			return err
+		}
+		recoveryCodes, err = replaceRecoveryCodes(r.Context(), qtx, userID)
+		return err
+	})
+	switch {
+	case errors.Is(err, errTOTPNotEnrolled), errors.Is(err, errInvalidMFACode):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, errTOTPAlreadyEnabled):
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
-	respondWithError(w, http.StatusNoContent, "")
+
+	respondWithJSON(w, http.StatusOK, struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// handlerCreateAPIKey makes a key for bots to act as the user within the
+// given scopes. The key is only shown in this response.
+func (apiCfg *apiConfig) handlerCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	type incomingParams struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	incParams := incomingParams{}
+	if err := json.NewDecoder(r.Body).Decode(&incParams); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	defer r.Body.Close()
+
+	name := strings.TrimSpace(incParams.Name)
+	if name == "" || len(name) > maxAPIKeyNameLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("name must be 1 to %d characters", maxAPIKeyNameLength))
+		return
+	}
+	scopes, err := auth.ParseScopes(incParams.Scopes)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Keys without scopes would be indistinguishable from a full login.
+	if len(scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one scope is required")
+		return
+	}
+	expiresAt := sql.NullTime{}
+	if incParams.ExpiresAt != nil {
+		if !incParams.ExpiresAt.After(time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "expires_at must be in the future")
+			return
+		}
+		// Timestamps are stored without a zone, in UTC.
+		expiresAt = sql.NullTime{Time: incParams.ExpiresAt.UTC(), Valid: true}
+	}
+
+	key, prefix, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	dbKey, err := apiCfg.db.CreateAPIKey(r.Context(), database.CreateAPIKeyParams{
+		UserID:    userIDFromContext(r.Context()),
+		Name:      name,
+		KeyHash:   auth.HashAPIKey(key),
+		Prefix:    prefix,
+		Scopes:    scopeNames(scopes),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := newAPIKeyResponse(dbKey)
+	response.Key = key
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+// handlerCreateOAuthClient registers an app that can ask users for access.
+// Confidential clients, which run on a server, get a secret to authenticate
+// with; it's only shown here.
+func (apiCfg *apiConfig) handlerCreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	type incomingParams struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+	incParams := incomingParams{}
+	if err := json.NewDecoder(r.Body).Decode(&incParams); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	defer r.Body.Close()
+
+	name := strings.TrimSpace(incParams.Name)
+	if name == "" || len(name) > maxOAuthClientNameLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("name must be 1 to %d characters", maxOAuthClientNameLength))
+		return
+	}
+	if len(incParams.RedirectURIs) == 0 || len(incParams.RedirectURIs) > maxRedirectURIs {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("redirect_uris must have 1 to %d URIs", maxRedirectURIs))
+		return
+	}
+	for _, uri := range incParams.RedirectURIs {
+		if err := checkRedirectURI(uri); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	secret := ""
+	secretHash := sql.NullString{}
+	if incParams.Confidential {
+		var err error
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashRefreshToken(secret), Valid: true}
+	}
+
+	dbClient, err := apiCfg.db.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		OwnerID:      userIDFromContext(r.Context()),
+		Name:         name,
+		SecretHash:   secretHash,
+		RedirectUris: incParams.RedirectURIs,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := newOAuthClientResponse(dbClient)
+	response.Secret = secret
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+// handlerOAuthAuthorize records the user's answer to an authorization
+// request, sent with the same query string handlerGetOAuthAuthorization
+// checked. Either way the response says where to send the user: back to the
+// client with an authorization code, or with access_denied.
+func (apiCfg *apiConfig) handlerOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	type incomingParams struct {
+		Approve bool `json:"approve"`
+	}
+	incParams := incomingParams{}
+	if err := json.NewDecoder(r.Body).Decode(&incParams); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+	defer r.Body.Close()
+
+	req, err := apiCfg.parseAuthorizationRequest(r.Context(), r.URL.Query())
+	if err != nil {
+		respondAuthorizationError(w, req, err)
+		return
+	}
+
+	type response struct {
+		RedirectTo string `json:"redirect_to"`
+	}
+	if !incParams.Approve {
+		respondWithJSON(w, http.StatusOK, response{
+			RedirectTo: req.redirectTo(url.Values{"error": {"access_denied"}}),
+		})
+		return
+	}
+
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	userID := userIDFromContext(r.Context())
+	err = apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		err := qtx.SaveOAuthConsent(r.Context(), database.SaveOAuthConsentParams{
+			UserID:   userID,
+			ClientID: req.Client.ID,
+			Scopes:   scopeNames(req.Scopes),
+		})
+		if err != nil {
+			return err
+		}
+		return qtx.CreateOAuthAuthorizationCode(r.Context(), database.CreateOAuthAuthorizationCodeParams{
+			CodeHash:      auth.HashRefreshToken(code),
+			ClientID:      req.Client.ID,
+			UserID:        userID,
+			RedirectUri:   req.RedirectURI,
+			Scopes:        scopeNames(req.Scopes),
+			CodeChallenge: req.CodeChallenge,
+			ExpiresAt:     time.Now().UTC().Add(oauthCodeTTL),
+		})
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		RedirectTo: req.redirectTo(url.Values{"code": {code}}),
+	})
+}
+
+// handlerOAuthToken is the OAuth token endpoint. It takes a form encoded
+// body and supports the authorization_code and refresh_token grants.
+func (apiCfg *apiConfig) handlerOAuthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, newOAuthError("invalid_request", "Couldn't parse form"))
+		return
+	}
+	client, err := apiCfg.authenticateOAuthClient(r)
+	if err != nil {
+		respondWithOAuthError(w, err)
+		return
+	}
+
+	var response oauthTokenResponse
+	var failure error
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		response, failure, err = apiCfg.redeemAuthorizationCode(r, client)
+	case "refresh_token":
+		response, failure, err = apiCfg.refreshOAuthGrant(r, client)
+	default:
+		failure = newOAuthError("unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if failure != nil {
+		respondWithOAuthError(w, failure)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// handlerOAuthIntrospect lets a confidential client check whether one of its
+// tokens is still active (RFC 7662).
+func (apiCfg *apiConfig) handlerOAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, newOAuthError("invalid_request", "Couldn't parse form"))
+		return
+	}
+	client, err := apiCfg.authenticateOAuthClient(r)
+	if err != nil {
+		respondWithOAuthError(w, err)
+		return
+	}
+	// Public clients can't authenticate, so anyone could introspect with
+	// their client_id.
+	if !client.SecretHash.Valid {
+		respondWithOAuthError(w, errInvalidOAuthClient)
+		return
+	}
+
+	introspection, err := apiCfg.introspectOAuthToken(r.Context(), client, r.PostForm.Get("token"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, http.StatusOK, introspection)
+}
+
+// handlerOAuthRevoke revokes an access or refresh token, and with it the
+// grant it came from (RFC 7009). Unknown tokens aren't an error: the client
+// wanted the token gone, and it is.
+func (apiCfg *apiConfig) handlerOAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, newOAuthError("invalid_request", "Couldn't parse form"))
+		return
+	}
+	client, err := apiCfg.authenticateOAuthClient(r)
+	if err != nil {
+		respondWithOAuthError(w, err)
+		return
+	}
+
+	if err := apiCfg.revokeOAuthToken(r.Context(), client, r.PostForm.Get("token")); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, struct{}{})
+}
+
+func (apiCfg apiConfig) handlerPolkaWebhook(w http.ResponseWriter, r *http.Request) {
+	type incomingParams struct {
+		Event string `json:"event"`
+		Data  struct {
+			UserID uuid.UUID `json:"user_id"`
+		} `json:"data"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	incParams := incomingParams{}
+	err := decoder.Decode(&incParams)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	apiKey, err := auth.GetAPIKey(r.Header)
+	validKey := apiCfg.polkaKey == apiKey
+	if err != nil || !validKey {
+		respondWithError(w, http.StatusUnauthorized, "")
+		return
+	}
+	defer r.Body.Close()
+
+	if incParams.Event == "user.upgraded" {
+		err = apiCfg.db.UpgradeUser(r.Context(), incParams.Data.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusNoContent, struct{}{})
+	}
+	respondWithError(w, http.StatusNoContent, "")
+}
+
+func (apiCfg *apiConfig) handlerFollowUser(w http.ResponseWriter, r *http.Request) {
+	followeeID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	if followeeID == userID {
+		respondWithError(w, http.StatusBadRequest, "You can't follow yourself")
+		return
+	}
+	if _, err := apiCfg.db.GetUser(r.Context(), followeeID); err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	err = apiCfg.db.FollowUser(r.Context(), database.FollowUserParams{
+		FollowerID: userID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusNoContent, struct{}{})
+}
+
+type likeStatus struct {
+	LikeCount int64 `json:"like_count"`
+	LikedByMe bool  `json:"liked_by_me"`
+}
+
+func (apiCfg *apiConfig) handlerLikeChirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	dbChirp, err := apiCfg.db.GetChirp(r.Context(), chirpID)
+	if err != nil || dbChirp.DeletedAt.Valid || !apiCfg.canSeeChirp(r.Context(), dbChirp, uuid.NullUUID{UUID: userID, Valid: true}) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+
+	// The (chirp_id, user_id) primary key makes repeated or concurrent likes
+	// from the same user a no-op.
+	err = apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		err := qtx.LikeChirp(r.Context(), database.LikeChirpParams{
+			ChirpID: chirpID,
+			UserID:  userID,
+		})
+		if err != nil {
+			return err
+		}
+		return qtx.AddNotifications(r.Context(), database.AddNotificationsParams{
+			ActorID: userID,
+			Kind:    notificationLike,
+			ChirpID: chirpID,
+			UserIds: []uuid.UUID{dbChirp.UserID},
+		})
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	chirp, err := apiCfg.chirpResponse(r.Context(), dbChirp, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, likeStatus{LikeCount: chirp.LikeCount, LikedByMe: chirp.LikedByMe})
+}
+
+func (apiCfg *apiConfig) handlerRechirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+	if err := apiCfg.checkNotSuspended(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	original, err := apiCfg.getOriginalChirp(r.Context(), chirpID, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+
+	status := http.StatusCreated
+	rechirp, err := apiCfg.db.AddRechirp(r.Context(), database.AddRechirpParams{
+		UserID:    userID,
+		RechirpOf: original.ID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// Rechirping twice is a no-op, hand back the existing rechirp.
+		status = http.StatusOK
+		rechirp, err = apiCfg.db.GetRechirp(r.Context(), database.GetRechirpParams{
+			UserID:    userID,
+			RechirpOf: uuid.NullUUID{UUID: original.ID, Valid: true},
+		})
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rechirp, err: "+err.Error())
+		return
+	}
+
+	chirp, err := apiCfg.chirpResponse(r.Context(), rechirp, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, status, chirp)
+}
+
+func (apiCfg *apiConfig) handlerMarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	type incomingParams struct {
+		IDs []uuid.UUID `json:"ids"`
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	// An empty body, or no ids, marks everything as read.
+	incParams := incomingParams{}
+	if r.ContentLength != 0 {
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&incParams); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+			return
+		}
+	}
+	defer r.Body.Close()
+
+	markParams := database.MarkNotificationsReadParams{UserID: userID}
+	if len(incParams.IDs) > 0 {
+		markParams.Ids = incParams.IDs
+	}
+	marked, err := apiCfg.db.MarkNotificationsRead(r.Context(), markParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		MarkedRead int64 `json:"marked_read"`
+	}{
+		MarkedRead: marked,
+	})
+}
+
+type Report struct {
+	ID         uuid.UUID  `json:"id"`
+	ChirpID    uuid.UUID  `json:"chirp_id"`
+	ReporterID uuid.UUID  `json:"reporter_id"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	ResolvedBy *uuid.UUID `json:"resolved_by"`
+	Chirp      *Chirp     `json:"chirp,omitempty"`
+}
+
+func newReportResponse(dbReport database.Report) Report {
+	report := Report{
+		ID:         dbReport.ID,
+		ChirpID:    dbReport.ChirpID,
+		ReporterID: dbReport.ReporterID,
+		Reason:     dbReport.Reason,
+		Details:    dbReport.Details,
+		Status:     dbReport.Status,
+		CreatedAt:  dbReport.CreatedAt,
+	}
+	if dbReport.ResolvedAt.Valid {
+		report.ResolvedAt = &dbReport.ResolvedAt.Time
+	}
+	if dbReport.ResolvedBy.Valid {
+		report.ResolvedBy = &dbReport.ResolvedBy.UUID
+	}
+	return report
+}
+
+const maxReportDetailsLength = 1000
+
+var reportReasons = map[string]bool{
+	"spam":           true,
+	"harassment":     true,
+	"hate":           true,
+	"violence":       true,
+	"misinformation": true,
+	"other":          true,
+}
+
+func (apiCfg *apiConfig) handlerReportChirp(w http.ResponseWriter, r *http.Request) {
+	type incomingParams struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	incParams := incomingParams{}
+	err = decoder.Decode(&incParams)
+
+	defer r.Body.Close()
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+
+	if !reportReasons[incParams.Reason] {
+		respondWithError(w, http.StatusBadRequest, "Reason must be one of spam, harassment, hate, violence, misinformation or other")
+		return
+	}
+	if len(incParams.Details) > maxReportDetailsLength {
+		respondWithError(w, http.StatusBadRequest, "Report details are too long")
+		return
+	}
+
+	dbChirp, err := apiCfg.getOriginalChirp(r.Context(), chirpID, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+	if dbChirp.UserID == userID {
+		respondWithError(w, http.StatusBadRequest, "You can't report your own chirp")
+		return
+	}
+
+	dbReport, err := apiCfg.db.CreateReport(r.Context(), database.CreateReportParams{
+		ChirpID:    dbChirp.ID,
+		ReporterID: userID,
+		Reason:     incParams.Reason,
+		Details:    incParams.Details,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusConflict, "You have already reported this chirp")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, newReportResponse(dbReport))
+}
+
+// handlerHideChirp takes a chirp down and closes the open reports against it.
+// Hiding an already hidden chirp is a no-op.
+func (apiCfg *apiConfig) handlerHideChirp(w http.ResponseWriter, r *http.Request) {
+	moderator := userFromContext(r.Context())
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		if _, err := qtx.HideChirp(r.Context(), chirpID); err != nil {
+			return err
+		}
+		return qtx.ResolveChirpReports(r.Context(), database.ResolveChirpReportsParams{
+			ChirpID:    chirpID,
+			ResolvedBy: uuid.NullUUID{UUID: moderator.ID, Valid: true},
+		})
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	apiCfg.respondWithModeratedChirp(w, r, chirpID, moderator.ID)
+}
+
+func (apiCfg *apiConfig) handlerRestoreChirp(w http.ResponseWriter, r *http.Request) {
+	moderator := userFromContext(r.Context())
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := apiCfg.db.RestoreChirp(r.Context(), chirpID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	apiCfg.respondWithModeratedChirp(w, r, chirpID, moderator.ID)
+}
+
+// respondWithModeratedChirp answers hide and restore requests with the chirp's
+// current state.
+func (apiCfg *apiConfig) respondWithModeratedChirp(w http.ResponseWriter, r *http.Request, chirpID, moderatorID uuid.UUID) {
+	dbChirp, err := apiCfg.db.GetChirp(r.Context(), chirpID)
+	if err != nil || dbChirp.DeletedAt.Valid {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+
+	chirp, err := apiCfg.chirpResponse(r.Context(), dbChirp, uuid.NullUUID{UUID: moderatorID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirp)
+}
+
+func (apiCfg *apiConfig) handlerDismissReport(w http.ResponseWriter, r *http.Request) {
+	moderator := userFromContext(r.Context())
+
+	reportID, err := uuid.Parse(r.PathValue("reportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	dismissed, err := apiCfg.db.DismissReport(r.Context(), database.DismissReportParams{
+		ID:         reportID,
+		ResolvedBy: uuid.NullUUID{UUID: moderator.ID, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if dismissed == 0 {
+		respondWithError(w, http.StatusNotFound, "Open report not found")
+		return
+	}
+	respondWithJSON(w, http.StatusNoContent, struct{}{})
+}
+
+var errCannotSuspend = errors.New("You can only suspend users with a lower role than yours")
+
+// handlerSuspendUser stops a user from logging in or posting, and signs them
+// out everywhere.
+func (apiCfg *apiConfig) handlerSuspendUser(w http.ResponseWriter, r *http.Request) {
+	moderator := userFromContext(r.Context())
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		target, err := qtx.GetUser(r.Context(), userID)
+		if err != nil {
+			return err
+		}
+		if auth.Role(target.Role).Includes(auth.Role(moderator.Role)) {
+			return errCannotSuspend
+		}
+		if _, err := qtx.SuspendUser(r.Context(), userID); err != nil {
+			return err
+		}
+		if err := qtx.RevokeUserRefreshTokens(r.Context(), userID); err != nil {
+			return err
+		}
+		if err := qtx.RevokeUserOAuthGrants(r.Context(), userID); err != nil {
+			return err
+		}
+		return qtx.IncrementTokenVersion(r.Context(), userID)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if errors.Is(err, errCannotSuspend) {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusNoContent, struct{}{})
+}
+
+// handlerUnlockUser forgets a user's failed logins, so a user locked out by
+// someone guessing their password can log in again right away. Throttling
+// of the addresses the guesses came from is left alone.
+func (apiCfg *apiConfig) handlerUnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := apiCfg.db.GetUser(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if _, err := apiCfg.db.ClearLoginThrottle(r.Context(), accountThrottleKey(user.Email)); err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusNoContent, struct{}{})
 }
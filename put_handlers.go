@@ -1,12 +1,15 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/LoronsoDev/chirpy/internal/auth"
 	"github.com/LoronsoDev/chirpy/internal/database"
+	"github.com/LoronsoDev/chirpy/internal/mail"
 	"github.com/google/uuid"
 )
 
@@ -19,20 +22,31 @@ func (apiCfg apiConfig) handlerUpdateCredentials(w http.ResponseWriter, r *http.
 	incParams := incomingParams{}
 	err := decoder.Decode(&incParams)
 
-	token, err := auth.GetBearerToken(r.Header)
-
 	defer r.Body.Close()
 
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Provided JWT token can't be retrieved correctly")
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
 		return
 	}
-	userID, err := auth.ValidateJWT(token, apiCfg.jwtSecret)
+
+	userID := userIDFromContext(r.Context())
+	currentUser, err := apiCfg.db.GetUser(r.Context(), userID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, err.Error())
+		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	// Only a new address is checked, so accounts made before addresses were
+	// checked can still change their password.
+	email := mail.CanonicalAddress(incParams.Email)
+	if email != currentUser.Email {
+		email, err = mail.NormalizeAddress(incParams.Email)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
 	hashedPassword, err := auth.HashPassword(incParams.Password)
 
 	if err != nil {
@@ -42,7 +56,7 @@ func (apiCfg apiConfig) handlerUpdateCredentials(w http.ResponseWriter, r *http.
 
 	credParams := database.ChangeUserCredentialsParams{
 		ID:             userID,
-		Email:          incParams.Email,
+		Email:          email,
 		HashedPassword: hashedPassword,
 	}
 
@@ -51,19 +65,196 @@ func (apiCfg apiConfig) handlerUpdateCredentials(w http.ResponseWriter, r *http.
 		respondWithError(w, http.StatusUnauthorized, err.Error())
 		return
 	}
+	// Changing the address unverifies it, so the new one gets a link.
+	// Changing just the password leaves it alone.
+	if userResource.Email != currentUser.Email && !userResource.EmailVerified {
+		if err := apiCfg.startEmailVerification(r.Context(), userResource); err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
 
 	retUserNoPassw := struct {
-		ID        uuid.UUID `json:"id"`
-		CreatedAt time.Time `json:"createdAt"`
-		UpdatedAt time.Time `json:"updatedAt"`
-		Email     string    `json:"email"`
-		ChirpyRed bool      `json:"is_chirpy_red"`
+		ID            uuid.UUID `json:"id"`
+		CreatedAt     time.Time `json:"createdAt"`
+		UpdatedAt     time.Time `json:"updatedAt"`
+		Email         string    `json:"email"`
+		EmailVerified bool      `json:"email_verified"`
+		ChirpyRed     bool      `json:"is_chirpy_red"`
 	}{
-		ID:        userResource.ID,
-		CreatedAt: userResource.CreatedAt,
-		UpdatedAt: userResource.UpdatedAt,
-		Email:     userResource.Email,
-		ChirpyRed: userResource.ChirpyRed,
+		ID:            userResource.ID,
+		CreatedAt:     userResource.CreatedAt,
+		UpdatedAt:     userResource.UpdatedAt,
+		Email:         userResource.Email,
+		EmailVerified: userResource.EmailVerified,
+		ChirpyRed:     userResource.ChirpyRed,
 	}
 	respondWithJSON(w, http.StatusOK, retUserNoPassw)
 }
+
+var (
+	errNotChirpOwner      = errors.New("you are not the original poster of this chirp")
+	errRechirpNotEditable = errors.New("rechirps have no body to edit")
+)
+
+func (apiCfg *apiConfig) handlerEditChirp(w http.ResponseWriter, r *http.Request) {
+	type incomingParams struct {
+		Body string `json:"body"`
+	}
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	incParams := incomingParams{}
+	err = decoder.Decode(&incParams)
+
+	defer r.Body.Close()
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	userID := userIDFromContext(r.Context())
+	if err := apiCfg.checkCanPost(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	verdict, err := apiCfg.cleanChirpBody(incParams.Body)
+	if err != nil {
+		respondWithChirpBodyError(w, err)
+		return
+	}
+
+	// The row lock keeps concurrent edits from both recording the same
+	// previous body as their revision.
+	var edited database.Chirp
+	err = apiCfg.withTx(r.Context(), func(qtx *database.Queries) error {
+		current, err := qtx.GetChirpForUpdate(r.Context(), chirpID)
+		if err != nil {
+			return err
+		}
+		if current.DeletedAt.Valid {
+			return sql.ErrNoRows
+		}
+		if current.UserID != userID {
+			return errNotChirpOwner
+		}
+		if current.RechirpOf.Valid {
+			return errRechirpNotEditable
+		}
+		if current.Body == verdict.Body {
+			edited = current
+			return nil
+		}
+
+		err = qtx.AddChirpRevision(r.Context(), database.AddChirpRevisionParams{
+			ChirpID:   current.ID,
+			Body:      current.Body,
+			CreatedAt: current.UpdatedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		edited, err = qtx.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+			ID:   current.ID,
+			Body: verdict.Body,
+		})
+		if err != nil {
+			return err
+		}
+		if err := indexChirpTags(r.Context(), qtx, edited); err != nil {
+			return err
+		}
+		return flagChirp(r.Context(), qtx, edited.ID, verdict)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+	if errors.Is(err, errNotChirpOwner) {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if errors.Is(err, errRechirpNotEditable) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't edit chirp, err: "+err.Error())
+		return
+	}
+
+	chirp, err := apiCfg.chirpResponse(r.Context(), edited, uuid.NullUUID{UUID: userID, Valid: true})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirp)
+}
+
+// handlerSetUserRole grants or revokes a role. Admins can't change their own
+// role, so there is always an admin left to undo a mistake.
+func (apiCfg *apiConfig) handlerSetUserRole(w http.ResponseWriter, r *http.Request) {
+	type incomingParams struct {
+		Role string `json:"role"`
+	}
+	admin := userFromContext(r.Context())
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	incParams := incomingParams{}
+	err = decoder.Decode(&incParams)
+
+	defer r.Body.Close()
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters")
+		return
+	}
+
+	role, err := auth.ParseRole(incParams.Role)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if userID == admin.ID {
+		respondWithError(w, http.StatusBadRequest, "You can't change your own role")
+		return
+	}
+
+	user, err := apiCfg.db.SetUserRole(r.Context(), database.SetUserRoleParams{
+		ID:   userID,
+		Role: string(role),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		ID        uuid.UUID `json:"id"`
+		UpdatedAt time.Time `json:"updated_at"`
+		Email     string    `json:"email"`
+		Role      string    `json:"role"`
+	}{
+		ID:        user.ID,
+		UpdatedAt: user.UpdatedAt,
+		Email:     user.Email,
+		Role:      user.Role,
+	})
+}
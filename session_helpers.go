@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/LoronsoDev/chirpy/internal/auth"
+	"github.com/LoronsoDev/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// clientIP is the address a request came from, shown in the session list.
+// X-Forwarded-For is ignored: without a proxy that overwrites it, clients can
+// put anything there.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// makeAccessToken issues an access token for one of a user's sessions.
+func (apiCfg *apiConfig) makeAccessToken(user database.User, sessionID uuid.UUID) (string, error) {
+	return apiCfg.jwtKeys.MakeJWT(auth.TokenSubject{
+		UserID:       user.ID,
+		Role:         auth.Role(user.Role),
+		SessionID:    sessionID,
+		TokenVersion: user.TokenVersion,
+	}, time.Hour)
+}
+
+// respondWithNewSession logs the user in: it starts a session, which
+// refreshing keeps going, and responds with its tokens.
+func (apiCfg *apiConfig) respondWithNewSession(w http.ResponseWriter, r *http.Request, user database.User) {
+	sessionID := uuid.New()
+	token, err := apiCfg.makeAccessToken(user, sessionID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	rtParams := database.AddRefreshTokenParams{
+		TokenHash: auth.HashRefreshToken(refreshToken),
+		UserID:    user.ID,
+		FamilyID:  sessionID,
+		UserAgent: r.UserAgent(),
+		IpAddress: clientIP(r),
+	}
+	err = apiCfg.db.AddRefreshToken(r.Context(), rtParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		ID            uuid.UUID `json:"id"`
+		CreatedAt     time.Time `json:"created_at"`
+		UpdatedAt     time.Time `json:"updated_at"`
+		Email         string    `json:"email"`
+		EmailVerified bool      `json:"email_verified"`
+		Token         string    `json:"token"`
+		RefreshToken  string    `json:"refresh_token"`
+		ChirpyRed     bool      `json:"is_chirpy_red"`
+		Role          string    `json:"role"`
+	}{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Token:         token,
+		RefreshToken:  refreshToken,
+		ChirpyRed:     user.ChirpyRed,
+		Role:          user.Role,
+	})
+}
+
+// checkTokenVersion rejects access tokens issued before the user last logged
+// out everywhere.
+func (apiCfg *apiConfig) checkTokenVersion(ctx context.Context) auth.ClaimCheck {
+	return func(claims auth.Claims) error {
+		userID, err := claims.UserID()
+		if err != nil {
+			return err
+		}
+		version, err := apiCfg.db.GetUserTokenVersion(ctx, userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return auth.ErrTokenRevoked
+		}
+		if err != nil {
+			return err
+		}
+		if claims.TokenVersion != version {
+			return auth.ErrTokenRevoked
+		}
+		return nil
+	}
+}
+
+// checkSessionActive rejects access tokens from a session the user has
+// revoked, instead of letting them run until they expire. OAuth tokens are
+// left to checkOAuthGrant.
+func (apiCfg *apiConfig) checkSessionActive(ctx context.Context) auth.ClaimCheck {
+	return func(claims auth.Claims) error {
+		if claims.ClientID != "" || claims.SessionID == uuid.Nil {
+			return nil
+		}
+		active, err := apiCfg.db.IsSessionActive(ctx, claims.SessionID)
+		if err != nil {
+			return err
+		}
+		if !active {
+			return auth.ErrTokenRevoked
+		}
+		return nil
+	}
+}
+
+// loadTokenValidator reads how access tokens are checked from the
+// environment. JWT_AUDIENCE defaults to chirpy-api and JWT_LEEWAY, the clock
+// skew tolerated on expiry, to 30s. JWT_ALGORITHMS optionally restricts the
+// accepted signing algorithms, as a comma separated list like "RS256,EdDSA".
+func loadTokenValidator() (auth.Validator, error) {
+	validator := auth.Validator{
+		Issuer:   auth.DefaultIssuer,
+		Audience: "chirpy-api",
+		Leeway:   30 * time.Second,
+	}
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		validator.Audience = audience
+	}
+	if leeway := os.Getenv("JWT_LEEWAY"); leeway != "" {
+		d, err := time.ParseDuration(leeway)
+		if err != nil || d < 0 {
+			return auth.Validator{}, fmt.Errorf("invalid JWT_LEEWAY %q", leeway)
+		}
+		validator.Leeway = d
+	}
+	for _, alg := range strings.Split(os.Getenv("JWT_ALGORITHMS"), ",") {
+		if alg = strings.TrimSpace(alg); alg != "" {
+			validator.Algorithms = append(validator.Algorithms, alg)
+		}
+	}
+	return validator, nil
+}
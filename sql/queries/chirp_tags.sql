@@ -0,0 +1,28 @@
+-- name: AddChirpTags :exec
+INSERT INTO chirp_tags (chirp_id, tag, created_at)
+SELECT sqlc.arg(chirp_id)::uuid, unnest(sqlc.arg(tags)::text[]), sqlc.arg(created_at)::timestamp
+ON CONFLICT DO NOTHING;
+
+-- name: RemoveChirpTags :exec
+DELETE FROM chirp_tags
+WHERE chirp_id = $1;
+
+-- name: ListTagChirps :many
+SELECT chirps.* FROM chirps
+JOIN chirp_tags ON chirp_tags.chirp_id = chirps.id
+WHERE chirp_tags.tag = sqlc.arg(tag)
+AND chirps.deleted_at IS NULL
+AND chirps.hidden_at IS NULL
+AND (
+    sqlc.narg(cursor_created_at)::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) < (sqlc.narg(cursor_created_at)::timestamp, sqlc.narg(cursor_id)::uuid)
+)
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg(page_limit);
+
+-- name: GetTrendingTags :many
+SELECT tag, COUNT(*) AS uses FROM chirp_tags
+WHERE created_at >= sqlc.arg(since)::timestamp
+GROUP BY tag
+ORDER BY uses DESC, tag ASC
+LIMIT sqlc.arg(tag_limit);
@@ -1,30 +1,151 @@
 -- name: AddChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
-VALUES (
-    gen_random_uuid(),
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, reply_to, thread_id, quote_of)
+SELECT
+    new_chirp.id,
     NOW(),
     NOW(),
-    $1,
-    $2
-)
+    sqlc.arg(body)::text,
+    sqlc.arg(user_id)::uuid,
+    sqlc.narg(reply_to)::uuid,
+    COALESCE(sqlc.narg(thread_id)::uuid, new_chirp.id),
+    sqlc.narg(quote_of)::uuid
+FROM (SELECT gen_random_uuid() AS id) AS new_chirp
 RETURNING *;
 
--- name: GetAllChirpsAscOrder :many
+-- name: AddRechirp :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, thread_id, rechirp_of)
+SELECT
+    new_chirp.id,
+    NOW(),
+    NOW(),
+    '',
+    sqlc.arg(user_id)::uuid,
+    new_chirp.id,
+    sqlc.arg(rechirp_of)::uuid
+FROM (SELECT gen_random_uuid() AS id) AS new_chirp
+ON CONFLICT (user_id, rechirp_of) WHERE rechirp_of IS NOT NULL DO NOTHING
+RETURNING *;
+
+-- name: GetRechirp :one
 SELECT * FROM chirps
-ORDER BY created_at ASC;
+WHERE user_id = $1 AND rechirp_of = $2;
+
+-- name: RemoveRechirp :execrows
+DELETE FROM chirps
+WHERE user_id = $1 AND rechirp_of = $2;
 
--- name: GetAllChirpsDescOrder :many
+-- name: RemoveRechirpsOf :exec
+DELETE FROM chirps
+WHERE rechirp_of = $1;
+
+-- name: GetChirpsByIDs :many
 SELECT * FROM chirps
-ORDER BY created_at DESC;
+WHERE id = ANY(sqlc.arg(ids)::uuid[]);
 
 -- name: GetChirp :one
 SELECT * FROM chirps
 WHERE id = $1;
 
+-- name: GetChirpForUpdate :one
+SELECT * FROM chirps
+WHERE id = $1
+FOR UPDATE;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: GetThread :many
+SELECT * FROM chirps
+WHERE thread_id = sqlc.arg(thread_id)
+AND (hidden_at IS NULL OR user_id = sqlc.narg(viewer_id)::uuid OR sqlc.arg(include_hidden)::bool)
+ORDER BY created_at ASC, id ASC;
+
+-- name: CountChirpReferences :one
+SELECT COUNT(*) FROM chirps
+WHERE reply_to = sqlc.arg(chirp_id)::uuid OR quote_of = sqlc.arg(chirp_id)::uuid;
+
+-- name: TombstoneChirp :exec
+UPDATE chirps
+SET body = '', deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
 -- name: RemoveChirp :exec
 DELETE FROM chirps
 WHERE id = $1;
 
--- name: GetChirpsFromUser :many
+-- name: ListChirpsAsc :many
+SELECT * FROM chirps
+WHERE (sqlc.narg(author_ids)::uuid[] IS NULL OR user_id = ANY(sqlc.narg(author_ids)::uuid[]))
+AND (sqlc.narg(since)::timestamp IS NULL OR created_at >= sqlc.narg(since)::timestamp)
+AND (sqlc.narg(until)::timestamp IS NULL OR created_at < sqlc.narg(until)::timestamp)
+AND deleted_at IS NULL
+AND (hidden_at IS NULL OR user_id = sqlc.narg(viewer_id)::uuid OR sqlc.arg(include_hidden)::bool)
+AND (
+    sqlc.narg(cursor_created_at)::timestamp IS NULL
+    OR (created_at, id) > (sqlc.narg(cursor_created_at)::timestamp, sqlc.narg(cursor_id)::uuid)
+)
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg(page_limit);
+
+-- name: ListChirpsDesc :many
 SELECT * FROM chirps
-WHERE user_id = $1;
\ No newline at end of file
+WHERE (sqlc.narg(author_ids)::uuid[] IS NULL OR user_id = ANY(sqlc.narg(author_ids)::uuid[]))
+AND (sqlc.narg(since)::timestamp IS NULL OR created_at >= sqlc.narg(since)::timestamp)
+AND (sqlc.narg(until)::timestamp IS NULL OR created_at < sqlc.narg(until)::timestamp)
+AND deleted_at IS NULL
+AND (hidden_at IS NULL OR user_id = sqlc.narg(viewer_id)::uuid OR sqlc.arg(include_hidden)::bool)
+AND (
+    sqlc.narg(cursor_created_at)::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg(cursor_created_at)::timestamp, sqlc.narg(cursor_id)::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(page_limit);
+
+-- name: ListTimeline :many
+SELECT chirps.* FROM chirps
+JOIN follows ON follows.followee_id = chirps.user_id
+WHERE follows.follower_id = sqlc.arg(follower_id)
+AND chirps.deleted_at IS NULL
+AND chirps.hidden_at IS NULL
+AND (
+    sqlc.narg(cursor_created_at)::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) < (sqlc.narg(cursor_created_at)::timestamp, sqlc.narg(cursor_id)::uuid)
+)
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg(page_limit);
+
+-- name: SearchChirps :many
+SELECT
+    chirps.*,
+    ts_rank_cd(to_tsvector('english', body), to_tsquery('english', sqlc.arg(query)::text))::real AS rank,
+    ts_headline(
+        'english',
+        body,
+        to_tsquery('english', sqlc.arg(query)::text),
+        'MaxFragments=2, MinWords=5, MaxWords=20, StartSel=' || chr(57344) || ', StopSel=' || chr(57345)
+    )::text AS snippet
+FROM chirps
+WHERE to_tsvector('english', body) @@ to_tsquery('english', sqlc.arg(query)::text)
+AND deleted_at IS NULL
+AND hidden_at IS NULL
+AND (sqlc.narg(author_ids)::uuid[] IS NULL OR user_id = ANY(sqlc.narg(author_ids)::uuid[]))
+AND (sqlc.narg(since)::timestamp IS NULL OR created_at >= sqlc.narg(since)::timestamp)
+AND (sqlc.narg(until)::timestamp IS NULL OR created_at < sqlc.narg(until)::timestamp)
+ORDER BY rank DESC, created_at DESC, id DESC
+LIMIT sqlc.arg(page_limit) OFFSET sqlc.arg(page_offset);
+
+-- name: HideChirp :execrows
+UPDATE chirps
+SET hidden_at = NOW()
+WHERE id = $1
+AND deleted_at IS NULL
+AND hidden_at IS NULL;
+
+-- name: RestoreChirp :execrows
+UPDATE chirps
+SET hidden_at = NULL
+WHERE id = $1
+AND hidden_at IS NOT NULL;
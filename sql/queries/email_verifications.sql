@@ -0,0 +1,12 @@
+-- name: CreateEmailVerification :exec
+INSERT INTO email_verifications (token_hash, user_id, email, created_at, expires_at)
+VALUES ($1, $2, $3, NOW(), $4);
+
+-- name: GetEmailVerificationForUpdate :one
+SELECT * FROM email_verifications
+WHERE token_hash = $1
+FOR UPDATE;
+
+-- name: DeleteUserEmailVerifications :exec
+DELETE FROM email_verifications
+WHERE user_id = $1;
@@ -0,0 +1,28 @@
+-- name: LockLoginThrottle :one
+-- Creates the row when it's missing, so concurrent logins for the same key
+-- always wait on the same row lock.
+INSERT INTO login_throttles (key, failures, last_failure_at)
+VALUES ($1, 0, NOW())
+ON CONFLICT (key) DO UPDATE
+SET key = EXCLUDED.key
+RETURNING *;
+
+-- name: RecordLoginFailure :exec
+-- The count starts over when the last failure is older than reset_before.
+INSERT INTO login_throttles (key, failures, last_failure_at)
+VALUES ($1, 1, NOW())
+ON CONFLICT (key) DO UPDATE
+SET failures = CASE
+        WHEN login_throttles.last_failure_at < sqlc.arg(reset_before) THEN 1
+        ELSE login_throttles.failures + 1
+    END,
+    last_failure_at = NOW();
+
+-- name: ForgetLoginFailure :exec
+UPDATE login_throttles
+SET failures = failures - 1
+WHERE key = $1 AND failures > 0;
+
+-- name: ClearLoginThrottle :execrows
+DELETE FROM login_throttles
+WHERE key = $1;
@@ -0,0 +1,9 @@
+-- name: ResolveMentions :many
+SELECT id, email FROM users
+WHERE lower(email) = ANY(sqlc.arg(emails)::text[])
+OR lower(split_part(email, '@', 1)) = ANY(sqlc.arg(handles)::text[]);
+
+-- name: AddMentions :exec
+INSERT INTO mentions (chirp_id, user_id, created_at)
+SELECT sqlc.arg(chirp_id)::uuid, unnest(sqlc.arg(user_ids)::uuid[]), NOW()
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,8 @@
+-- name: ListModerationRules :many
+SELECT * FROM moderation_rules
+ORDER BY word;
+
+-- name: AddChirpFlags :exec
+INSERT INTO chirp_flags (chirp_id, word)
+SELECT sqlc.arg(chirp_id)::uuid, unnest(sqlc.arg(words)::text[])
+ON CONFLICT DO NOTHING;
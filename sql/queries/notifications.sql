@@ -0,0 +1,35 @@
+-- name: AddNotifications :exec
+INSERT INTO notifications (id, created_at, user_id, actor_id, kind, chirp_id, read_at)
+SELECT
+    gen_random_uuid(),
+    NOW(),
+    recipient,
+    sqlc.arg(actor_id)::uuid,
+    sqlc.arg(kind)::text,
+    sqlc.arg(chirp_id)::uuid,
+    NULL
+FROM unnest(sqlc.arg(user_ids)::uuid[]) AS recipient
+WHERE recipient <> sqlc.arg(actor_id)::uuid
+ON CONFLICT DO NOTHING;
+
+-- name: ListNotifications :many
+SELECT * FROM notifications
+WHERE user_id = sqlc.arg(user_id)
+AND (NOT sqlc.arg(unread_only)::boolean OR read_at IS NULL)
+AND (
+    sqlc.narg(cursor_created_at)::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg(cursor_created_at)::timestamp, sqlc.narg(cursor_id)::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(page_limit);
+
+-- name: CountUnreadNotifications :one
+SELECT COUNT(*) FROM notifications
+WHERE user_id = $1 AND read_at IS NULL;
+
+-- name: MarkNotificationsRead :execrows
+UPDATE notifications
+SET read_at = NOW()
+WHERE user_id = sqlc.arg(user_id)
+AND read_at IS NULL
+AND (sqlc.narg(ids)::uuid[] IS NULL OR id = ANY(sqlc.narg(ids)::uuid[]));
@@ -0,0 +1,95 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, owner_id, name, secret_hash, redirect_uris, created_at)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, NOW())
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients
+WHERE id = $1;
+
+-- name: ListUserOAuthClients :many
+SELECT * FROM oauth_clients
+WHERE owner_id = $1
+ORDER BY created_at DESC;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients
+WHERE id = $1 AND owner_id = $2;
+
+-- name: GetOAuthConsent :one
+SELECT * FROM oauth_consents
+WHERE user_id = $1 AND client_id = $2;
+
+-- name: SaveOAuthConsent :exec
+INSERT INTO oauth_consents (user_id, client_id, scopes, created_at, updated_at)
+VALUES ($1, $2, $3, NOW(), NOW())
+ON CONFLICT (user_id, client_id) DO UPDATE
+SET scopes = EXCLUDED.scopes, updated_at = NOW();
+
+-- name: ListUserOAuthConsents :many
+SELECT oauth_consents.client_id, oauth_clients.name AS client_name, oauth_consents.scopes,
+    oauth_consents.created_at, oauth_consents.updated_at
+FROM oauth_consents
+JOIN oauth_clients ON oauth_clients.id = oauth_consents.client_id
+WHERE oauth_consents.user_id = $1
+ORDER BY oauth_consents.updated_at DESC;
+
+-- name: DeleteOAuthConsent :execrows
+DELETE FROM oauth_consents
+WHERE user_id = $1 AND client_id = $2;
+
+-- name: CreateOAuthGrant :one
+INSERT INTO oauth_grants (id, client_id, user_id, scopes, created_at)
+VALUES (gen_random_uuid(), $1, $2, $3, NOW())
+RETURNING *;
+
+-- name: GetOAuthGrant :one
+SELECT * FROM oauth_grants
+WHERE id = $1;
+
+-- name: RevokeOAuthGrant :exec
+UPDATE oauth_grants
+SET revoked_at = COALESCE(revoked_at, NOW())
+WHERE id = $1;
+
+-- name: RevokeUserClientOAuthGrants :exec
+UPDATE oauth_grants
+SET revoked_at = NOW()
+WHERE user_id = $1 AND client_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeUserOAuthGrants :exec
+UPDATE oauth_grants
+SET revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: CreateOAuthAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, NOW(), $7);
+
+-- name: GetOAuthAuthorizationCodeForUpdate :one
+SELECT * FROM oauth_authorization_codes
+WHERE code_hash = $1
+FOR UPDATE;
+
+-- name: RedeemOAuthAuthorizationCode :exec
+UPDATE oauth_authorization_codes
+SET grant_id = $2
+WHERE code_hash = $1;
+
+-- name: AddOAuthRefreshToken :exec
+INSERT INTO oauth_refresh_tokens (token_hash, grant_id, created_at, expires_at)
+VALUES ($1, $2, NOW(), NOW() + INTERVAL '60 days');
+
+-- name: GetOAuthRefreshToken :one
+SELECT * FROM oauth_refresh_tokens
+WHERE token_hash = $1;
+
+-- name: GetOAuthRefreshTokenForUpdate :one
+SELECT * FROM oauth_refresh_tokens
+WHERE token_hash = $1
+FOR UPDATE;
+
+-- name: RevokeOAuthRefreshToken :exec
+UPDATE oauth_refresh_tokens
+SET revoked_at = NOW()
+WHERE token_hash = $1;
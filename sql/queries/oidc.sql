@@ -0,0 +1,17 @@
+-- name: CreateOIDCLogin :exec
+INSERT INTO oidc_logins (state_hash, nonce, code_verifier, created_at, expires_at)
+VALUES ($1, $2, $3, NOW(), $4);
+
+-- name: TakeOIDCLogin :one
+-- Deleting as it's read makes each state usable once.
+DELETE FROM oidc_logins
+WHERE state_hash = $1
+RETURNING *;
+
+-- name: GetUserIdentity :one
+SELECT * FROM user_identities
+WHERE issuer = $1 AND subject = $2;
+
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (issuer, subject, user_id, email, created_at)
+VALUES ($1, $2, $3, $4, NOW());
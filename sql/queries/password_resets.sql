@@ -0,0 +1,15 @@
+-- name: CreatePasswordReset :exec
+INSERT INTO password_resets (token_hash, user_id, created_at, expires_at)
+VALUES ($1, $2, NOW(), $3);
+
+-- name: GetPasswordResetForUpdate :one
+SELECT * FROM password_resets
+WHERE token_hash = $1
+FOR UPDATE;
+
+-- name: UsePasswordResets :exec
+-- Spends every outstanding token of the user, not just the one used, so an
+-- older email can't be used to reset the password again.
+UPDATE password_resets
+SET used_at = NOW()
+WHERE user_id = $1 AND used_at IS NULL;
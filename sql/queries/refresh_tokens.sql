@@ -1,20 +1,72 @@
 -- name: AddRefreshToken :exec
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at)
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address)
 VALUES (
     $1,
     NOW(),
     NOW(),
     $2,
     NOW() + INTERVAL '60 days',
-    NULL
+    NULL,
+    $3,
+    $4,
+    $5
 )
 RETURNING *;
 
 -- name: GetTokenInfo :one
 SELECT * from refresh_tokens
-WHERE token = $1;
+WHERE token_hash = $1;
+
+-- name: GetTokenInfoForUpdate :one
+SELECT * from refresh_tokens
+WHERE token_hash = $1
+FOR UPDATE;
 
 -- name: RevokeToken :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW(), updated_at = NOW()
-WHERE token = $1;
+WHERE token_hash = $1;
+
+-- name: RevokeTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1
+AND revoked_at IS NULL;
+
+-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1
+AND revoked_at IS NULL;
+
+-- name: ListSessions :many
+-- A session is a token family; its live token is the one issued by the
+-- latest login or refresh.
+SELECT
+    family_id,
+    user_agent,
+    ip_address,
+    (SELECT MIN(first.created_at) FROM refresh_tokens first WHERE first.family_id = refresh_tokens.family_id)::timestamp AS started_at,
+    created_at AS last_used_at,
+    expires_at
+FROM refresh_tokens
+WHERE user_id = $1
+AND revoked_at IS NULL
+AND expires_at > NOW()
+ORDER BY created_at DESC;
+
+-- name: IsSessionActive :one
+-- Refreshing revokes the old token and adds the new one together, so a
+-- session is live as long as any of its tokens isn't revoked.
+SELECT EXISTS (
+    SELECT 1 FROM refresh_tokens
+    WHERE family_id = $1
+    AND revoked_at IS NULL
+);
+
+-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1
+AND user_id = $2
+AND revoked_at IS NULL;
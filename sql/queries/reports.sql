@@ -0,0 +1,34 @@
+-- name: CreateReport :one
+INSERT INTO reports (id, chirp_id, reporter_id, reason, details, created_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    NOW()
+)
+ON CONFLICT (chirp_id, reporter_id) WHERE status = 'open' DO NOTHING
+RETURNING *;
+
+-- name: ListReports :many
+SELECT * FROM reports
+WHERE status = sqlc.arg(status)
+AND (
+    sqlc.narg(cursor_created_at)::timestamp IS NULL
+    OR (created_at, id) > (sqlc.narg(cursor_created_at)::timestamp, sqlc.narg(cursor_id)::uuid)
+)
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg(page_limit);
+
+-- name: ResolveChirpReports :exec
+UPDATE reports
+SET status = 'resolved', resolved_at = NOW(), resolved_by = $2
+WHERE chirp_id = $1
+AND status = 'open';
+
+-- name: DismissReport :execrows
+UPDATE reports
+SET status = 'dismissed', resolved_at = NOW(), resolved_by = $2
+WHERE id = $1
+AND status = 'open';
@@ -9,13 +9,28 @@ VALUES (
 )
 RETURNING *;
 
+-- name: CreateExternalUser :one
+-- Users who sign in with an identity provider have no password, so they
+-- can't log in with one until they reset it.
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, email_verified)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    '',
+    true
+)
+RETURNING *;
+
 -- name: GetUserByEmail :one
 SELECT * FROM users
 WHERE email = $1;
 
 -- name: ChangeUserCredentials :one
+-- A new address has to be verified again.
 UPDATE users
-SET email = $2, hashed_password = $3
+SET email = $2, hashed_password = $3, email_verified = email_verified AND email = $2
 WHERE id = $1
 RETURNING *;
 
@@ -27,4 +42,52 @@ WHERE id = $1;
 -- name: DowngradeUser :exec
 UPDATE users
 SET chirpy_red = false
-WHERE id = $1;
\ No newline at end of file
+WHERE id = $1;
+
+-- name: GetUser :one
+SELECT * FROM users
+WHERE id = $1;
+
+-- name: SuspendUser :execrows
+UPDATE users
+SET suspended_at = COALESCE(suspended_at, NOW())
+WHERE id = $1;
+
+-- name: UnsuspendUser :execrows
+UPDATE users
+SET suspended_at = NULL
+WHERE id = $1;
+
+-- name: SetUserRole :one
+UPDATE users
+SET role = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: PromoteAdmins :execrows
+-- Only verified addresses are promoted, or anyone could sign up with an
+-- admin's address before its owner does.
+UPDATE users
+SET role = 'admin', updated_at = NOW()
+WHERE email = ANY(sqlc.arg(emails)::text[])
+AND email_verified
+AND role <> 'admin';
+
+-- name: GetUserTokenVersion :one
+SELECT token_version FROM users
+WHERE id = $1;
+
+-- name: IncrementTokenVersion :exec
+UPDATE users
+SET token_version = token_version + 1
+WHERE id = $1;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: VerifyUserEmail :execrows
+UPDATE users
+SET email_verified = true, updated_at = NOW()
+WHERE id = $1 AND email = $2;
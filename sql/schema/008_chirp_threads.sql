@@ -0,0 +1,19 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN reply_to UUID REFERENCES chirps(id) ON DELETE SET NULL,
+ADD COLUMN thread_id UUID,
+ADD COLUMN deleted_at TIMESTAMP;
+
+UPDATE chirps SET thread_id = id;
+
+ALTER TABLE chirps
+ALTER COLUMN thread_id SET NOT NULL;
+
+CREATE INDEX chirps_thread_id_idx ON chirps (thread_id, created_at, id);
+CREATE INDEX chirps_reply_to_idx ON chirps (reply_to);
+
+-- +goose Down
+ALTER TABLE chirps
+DROP COLUMN deleted_at,
+DROP COLUMN thread_id,
+DROP COLUMN reply_to;
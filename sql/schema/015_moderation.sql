@@ -0,0 +1,17 @@
+-- +goose Up
+CREATE TABLE moderation_rules (
+    word TEXT PRIMARY KEY,
+    action TEXT NOT NULL CHECK (action IN ('mask', 'reject', 'flag')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE chirp_flags (
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    word TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (chirp_id, word)
+);
+
+-- +goose Down
+DROP TABLE chirp_flags;
+DROP TABLE moderation_rules;
@@ -0,0 +1,34 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN hidden_at TIMESTAMP;
+
+-- Stopgap until users get proper roles.
+ALTER TABLE users
+ADD COLUMN suspended_at TIMESTAMP,
+ADD COLUMN is_moderator BOOLEAN DEFAULT FALSE NOT NULL;
+
+CREATE TABLE reports (
+    id UUID PRIMARY KEY,
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    reporter_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL CHECK (reason IN ('spam', 'harassment', 'hate', 'violence', 'misinformation', 'other')),
+    details TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'resolved', 'dismissed')),
+    created_at TIMESTAMP NOT NULL,
+    resolved_at TIMESTAMP,
+    resolved_by UUID REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- A user can only have one open report per chirp.
+CREATE UNIQUE INDEX reports_open_chirp_reporter_idx ON reports (chirp_id, reporter_id) WHERE status = 'open';
+CREATE INDEX reports_status_created_at_idx ON reports (status, created_at, id);
+
+-- +goose Down
+DROP TABLE reports;
+
+ALTER TABLE users
+DROP COLUMN is_moderator,
+DROP COLUMN suspended_at;
+
+ALTER TABLE chirps
+DROP COLUMN hidden_at;
@@ -0,0 +1,17 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin'));
+
+UPDATE users SET role = 'moderator' WHERE is_moderator;
+
+ALTER TABLE users
+DROP COLUMN is_moderator;
+
+-- +goose Down
+ALTER TABLE users
+ADD COLUMN is_moderator BOOLEAN DEFAULT FALSE NOT NULL;
+
+UPDATE users SET is_moderator = TRUE WHERE role IN ('moderator', 'admin');
+
+ALTER TABLE users
+DROP COLUMN role;
@@ -0,0 +1,29 @@
+-- +goose Up
+-- Refresh tokens are stored as SHA-256 hashes, and every token issued by
+-- rotating another one belongs to the same family as the login it came from.
+ALTER TABLE refresh_tokens
+ADD COLUMN family_id UUID;
+
+UPDATE refresh_tokens
+SET token = encode(sha256(token::bytea), 'hex'), family_id = gen_random_uuid();
+
+ALTER TABLE refresh_tokens
+ALTER COLUMN family_id SET NOT NULL;
+
+ALTER TABLE refresh_tokens
+RENAME COLUMN token TO token_hash;
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+-- +goose Down
+-- Hashed tokens can't be turned back into usable ones, so everyone has to
+-- log in again.
+DELETE FROM refresh_tokens;
+
+DROP INDEX refresh_tokens_family_id_idx;
+
+ALTER TABLE refresh_tokens
+RENAME COLUMN token_hash TO token;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN family_id;
@@ -0,0 +1,31 @@
+-- +goose Up
+-- Addresses are compared case-insensitively from now on, so they're stored
+-- lowercased. Accounts that only differ by case have to be merged by hand
+-- before this runs.
+UPDATE users
+SET email = lower(email);
+
+ALTER TABLE users
+ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT false;
+
+-- Accounts from before verification existed keep working.
+UPDATE users
+SET email_verified = true;
+
+-- email is the address the token verifies, so a token sent before the user
+-- changed their address can't verify the new one.
+CREATE TABLE email_verifications(
+    token_hash TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX email_verifications_user_id_idx ON email_verifications (user_id);
+
+-- +goose Down
+DROP TABLE email_verifications;
+
+ALTER TABLE users
+DROP COLUMN email_verified;
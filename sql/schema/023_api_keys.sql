@@ -0,0 +1,20 @@
+-- +goose Up
+-- Only a hash of each key is stored. prefix is the start of the key, kept so
+-- users can tell their keys apart.
+CREATE TABLE api_keys(
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    prefix TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX api_keys_user_id_idx ON api_keys (user_id, created_at);
+
+-- +goose Down
+DROP TABLE api_keys;
@@ -0,0 +1,70 @@
+-- +goose Up
+-- Apps users register to act on behalf of other users. Public clients, like
+-- mobile apps, can't keep a secret and have no secret_hash; every client
+-- has to use PKCE either way.
+CREATE TABLE oauth_clients(
+    id UUID PRIMARY KEY,
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    secret_hash TEXT,
+    redirect_uris TEXT[] NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX oauth_clients_owner_id_idx ON oauth_clients (owner_id, created_at);
+
+-- The scopes a user approved for a client, so they're only asked again for
+-- new ones.
+CREATE TABLE oauth_consents(
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_id UUID NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, client_id)
+);
+
+-- Everything issued from one authorization code: access tokens carry the
+-- grant id as their sid, and refresh tokens rotate within it. Revoking the
+-- grant revokes all of them.
+CREATE TABLE oauth_grants(
+    id UUID PRIMARY KEY,
+    client_id UUID NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX oauth_grants_user_id_client_id_idx ON oauth_grants (user_id, client_id);
+
+-- grant_id is set once the code is redeemed, so redeeming it again can
+-- revoke what the first redemption issued.
+CREATE TABLE oauth_authorization_codes(
+    code_hash TEXT PRIMARY KEY,
+    client_id UUID NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    code_challenge TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    grant_id UUID REFERENCES oauth_grants(id) ON DELETE CASCADE
+);
+
+CREATE TABLE oauth_refresh_tokens(
+    token_hash TEXT PRIMARY KEY,
+    grant_id UUID NOT NULL REFERENCES oauth_grants(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX oauth_refresh_tokens_grant_id_idx ON oauth_refresh_tokens (grant_id);
+
+-- +goose Down
+DROP TABLE oauth_refresh_tokens;
+DROP TABLE oauth_authorization_codes;
+DROP TABLE oauth_grants;
+DROP TABLE oauth_consents;
+DROP TABLE oauth_clients;
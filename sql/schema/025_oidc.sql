@@ -0,0 +1,28 @@
+-- +goose Up
+-- Sign-ins started with the identity provider and not yet back. The state
+-- is only stored hashed; the nonce and code verifier are checked against
+-- what the provider returns.
+CREATE TABLE oidc_logins(
+    state_hash TEXT PRIMARY KEY,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- Provider accounts linked to users. The subject is what identifies the
+-- account; the email is only what it was when it was linked.
+CREATE TABLE user_identities(
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
+
+-- +goose Down
+DROP TABLE user_identities;
+DROP TABLE oidc_logins;
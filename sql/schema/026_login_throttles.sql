@@ -0,0 +1,13 @@
+-- +goose Up
+-- Failed logins, counted per account and per client IP. The key is
+-- "account:" followed by the email, so guesses at addresses nobody
+-- registered are throttled the same as real ones, or "ip:" followed by the
+-- address.
+CREATE TABLE login_throttles(
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE login_throttles;
@@ -0,0 +1,10 @@
+-- +goose Up
+-- ResolveMentions matches @handles against the local part of addresses and
+-- full addresses case insensitively; without these every chirp with a
+-- mention would scan the users table.
+CREATE INDEX users_handle_idx ON users (lower(split_part(email, '@', 1)));
+CREATE INDEX users_lower_email_idx ON users (lower(email));
+
+-- +goose Down
+DROP INDEX users_lower_email_idx;
+DROP INDEX users_handle_idx;
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/LoronsoDev/chirpy/internal/auth"
+	"github.com/LoronsoDev/chirpy/internal/database"
+	"github.com/LoronsoDev/chirpy/internal/throttle"
+	"github.com/google/uuid"
+)
+
+const (
+	// totpIssuer is the account name shown in authenticator apps.
+	totpIssuer = "Chirpy"
+	// mfaChallengeTTL is how long a user has to enter their code after
+	// their password.
+	mfaChallengeTTL = 5 * time.Minute
+	// maxMFAAttempts is how many wrong codes a challenge takes before the
+	// user has to enter their password again.
+	maxMFAAttempts    = 5
+	recoveryCodeCount = 10
+)
+
+// mfaPolicy backs off wrong second factors across all of a user's
+// challenges. A new challenge only takes the password, which whoever is
+// guessing codes already has, so maxMFAAttempts alone doesn't stop them.
+var mfaPolicy = throttle.Policy{
+	Free:  maxMFAAttempts,
+	Base:  time.Minute,
+	Max:   time.Hour,
+	Reset: 24 * time.Hour,
+}
+
+var (
+	errInvalidMFACode      = errors.New("Invalid two-factor code")
+	errInvalidMFAChallenge = errors.New("Login challenge is invalid or has expired")
+	errTOTPAlreadyEnabled  = errors.New("Two-factor authentication is already enabled")
+	errTOTPNotEnabled      = errors.New("Two-factor authentication is not enabled")
+	errTOTPNotEnrolled     = errors.New("Start two-factor enrollment first")
+)
+
+// totpEnabled reports whether the user has confirmed a TOTP secret.
+func (apiCfg *apiConfig) totpEnabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	totp, err := apiCfg.db.GetUserTOTP(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return totp.ConfirmedAt.Valid, nil
+}
+
+// respondWithMFAChallenge answers a correct password from a user with
+// two-factor authentication on. The challenge token stands in for the
+// password when the code is sent.
+func (apiCfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, r *http.Request, user database.User) {
+	// Challenge tokens are random like refresh tokens, so they're stored the
+	// same way.
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	expiresAt := time.Now().UTC().Add(mfaChallengeTTL)
+	err = apiCfg.db.CreateMFAChallenge(r.Context(), database.CreateMFAChallengeParams{
+		TokenHash: auth.HashRefreshToken(token),
+		UserID:    user.ID,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		MFARequired bool      `json:"mfa_required"`
+		MFAToken    string    `json:"mfa_token"`
+		ExpiresAt   time.Time `json:"expires_at"`
+	}{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresAt:   expiresAt,
+	})
+}
+
+// checkSecondFactor accepts either a TOTP code or an unused recovery code,
+// spending whichever was used. It returns errTOTPNotEnabled when the user
+// has no confirmed secret and errInvalidMFACode when neither code is right.
+func checkSecondFactor(ctx context.Context, qtx *database.Queries, userID uuid.UUID, code, recoveryCode string) error {
+	totp, err := qtx.GetUserTOTPForUpdate(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !totp.ConfirmedAt.Valid) {
+		return errTOTPNotEnabled
+	}
+	if err != nil {
+		return err
+	}
+
+	if recoveryCode != "" {
+		rows, err := qtx.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashRecoveryCode(recoveryCode),
+		})
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return errInvalidMFACode
+		}
+		return nil
+	}
+
+	step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now(), totp.LastUsedStep)
+	if !ok {
+		return errInvalidMFACode
+	}
+	return qtx.SetTOTPLastUsedStep(ctx, database.SetTOTPLastUsedStepParams{
+		UserID:       userID,
+		LastUsedStep: step,
+	})
+}
+
+func mfaThrottleKey(userID uuid.UUID) string {
+	return "mfa:" + userID.String()
+}
+
+// verifySecondFactor is checkSecondFactor with the user's wrong codes
+// counted, and only forgotten once a code is right. After too many, it
+// returns how long to wait without checking the code. The count is kept in
+// qtx, so its transaction has to commit even when the code is wrong.
+func verifySecondFactor(ctx context.Context, qtx *database.Queries, userID uuid.UUID, code, recoveryCode string) (time.Duration, error) {
+	key := mfaThrottleKey(userID)
+	row, err := qtx.LockLoginThrottle(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	if wait := mfaPolicy.RetryAfter(int(row.Failures), row.LastFailureAt, now); wait > 0 {
+		return wait, nil
+	}
+
+	err = checkSecondFactor(ctx, qtx, userID, code, recoveryCode)
+	if errors.Is(err, errInvalidMFACode) {
+		err := qtx.RecordLoginFailure(ctx, database.RecordLoginFailureParams{
+			Key:         key,
+			ResetBefore: mfaPolicy.ResetBefore(now),
+		})
+		if err != nil {
+			return 0, err
+		}
+		return 0, errInvalidMFACode
+	}
+	if err != nil {
+		return 0, err
+	}
+	_, err = qtx.ClearLoginThrottle(ctx, key)
+	return 0, err
+}
+
+// replaceRecoveryCodes invalidates the user's recovery codes and returns a
+// fresh set. Only their hashes are stored, so this is the one time they can
+// be shown.
+func replaceRecoveryCodes(ctx context.Context, qtx *database.Queries, userID uuid.UUID) ([]string, error) {
+	codes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, auth.HashRecoveryCode(code))
+	}
+
+	if err := qtx.DeleteUserRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+	err = qtx.CreateRecoveryCodes(ctx, database.CreateRecoveryCodesParams{
+		CodeHashes: hashes,
+		UserID:     userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}